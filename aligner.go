@@ -0,0 +1,550 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: aligner module.
+// Aligners hold the SNP profile and parameters used for calculating distances between reads and
+// "starred" multigenomes. An aligner is not modified after it is created, so it can be used from
+// many goroutines at the same time.
+// Copyright 2014 Nam Sy Vo
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"fmt"
+)
+
+//-------------------------------------------------------------------------------------------------
+// Aligners and alignment results.
+//-------------------------------------------------------------------------------------------------
+
+// Config holds the parameters of an aligner.
+type Config struct {
+	DistThres int    // distance threshold for early break
+	Ins       int    // cost of a read base which is not in the genome outside variant sites, 0 for none
+	Del       int    // cost of a genome base which is not in the read outside variant sites, 0 for none
+	GapOpen   int    // additional cost of each gap of Ins or Del bases, 0 for linear gap costs
+	Scorer    Scorer // costs of mismatches and alleles, nil for EditScorer
+
+	// Mode selects the ends of the read which may be soft-clipped (ModeGlobal for none). Clipping l
+	// bases at one end costs Clip + l*ClipBase (ClipBase 0 for 1): as aligned bases cost nothing
+	// when they match, each clipped base must cost something, like the match reward of local
+	// alignment scores, or clipping everything but a few bases would be cheapest.
+	Mode     AlignMode
+	Clip     int
+	ClipBase int
+
+	// AlleleMismatches scores read bases against an allele by their mismatches (with the costs of
+	// Scorer.Sub) instead of Scorer.Allele, so that sequencing errors at variant sites do not make
+	// reads unalignable.
+	AlleleMismatches bool
+
+	// PriorWeight weights the prior penalty of alleles with known frequencies, 10*log10(fmax/f)
+	// for the most frequent allele fmax of the site; 0 for none (see Profile.HasFreqs).
+	PriorWeight float64
+}
+
+// DefaultConfig returns the default aligner parameters.
+func DefaultConfig() Config {
+	return Config{DistThres: INF}
+}
+
+// Aligner calculates distances and alignments between reads and a multigenome.
+type Aligner struct {
+	profile  *Profile
+	same_len map[int]int // same-length sites, if given separately from the profile (see Init)
+	circular []Contig    // circular contigs, in genome order
+	cfg      Config
+}
+
+// NewAligner creates an aligner for a multigenome. Genome parts which run past the end of a
+// circular contig continue at its start, see Multigenome.Segment.
+func NewAligner(mg *Multigenome, cfg Config) *Aligner {
+	a := &Aligner{profile: mg.profile, cfg: cfg}
+	for _, c := range mg.contigs {
+		if c.Circular {
+			a.circular = append(a.circular, c)
+		}
+	}
+	return a
+}
+
+// NewProfileAligner creates an aligner for a SNP profile only, the genome is given with each read.
+func NewProfileAligner(profile *Profile, cfg Config) *Aligner {
+	return &Aligner{profile: profile, cfg: cfg}
+}
+
+// Config returns the parameters of the aligner.
+func (a *Aligner) Config() Config {
+	return a.cfg
+}
+
+// Result is the result of a distance calculation between a read s and a part t of a multigenome.
+// The read is first aligned without indels from the anchored end (the end of s and t in backward
+// direction, their start in forward direction) until a variant site with alleles of different lengths;
+// the remaining M bases of the read and N bases of the genome are aligned by dynamic programming.
+// If the aligner allows insertions and deletions (Config.Ins, Config.Del), or soft-clipping
+// (Config.Mode), the whole read is aligned by dynamic programming, and DPDist includes the costs of
+// the clipped ends.
+type Result struct {
+	Dist   int            // distance of the part aligned without dynamic programming
+	DPDist int            // distance of the part aligned by dynamic programming
+	M, N   int            // lengths of the read and genome parts aligned by dynamic programming
+	Calls  map[int][]byte // read bases at variant sites aligned without dynamic programming
+	Trace  [][][]byte     // alleles chosen at variant sites by dynamic programming, nil with a Workspace
+	Prior  int            // allele prior penalties included in Dist and DPDist, see Config.PriorWeight
+	ws     *Workspace     // DP matrices of the alignment, nil without dynamic programming
+	kern   int            // leading DP columns aligned by the bit-parallel kernel, without edit operations
+	q      []byte         // base qualities of the read, nil without, for the costs of ties and alignments
+	OK     bool           // false if the alignment was abandoned because it exceeds Config.DistThres
+
+	FreeClip   int // read bases soft-clipped at the free end (the start of s in backward direction)
+	AnchorClip int // read bases soft-clipped at the anchored end, in ModeLocal
+	skip       int // genome bases left out at the anchored end, in ModeLocal
+}
+
+// Distance returns the total distance of an alignment.
+func (r *Result) Distance() int {
+	return r.Dist + r.DPDist
+}
+
+//-------------------------------------------------------------------------------------------------
+// Calculate the distance between s and t in backward direction.
+// 	s is a read.
+// 	t is part of a multi-genome starting at pos.
+// The reads include standard bases, the multi-genomes include standard bases and "*" characters.
+//-------------------------------------------------------------------------------------------------
+func (a *Aligner) Backward(s, t []byte, pos int) Result {
+	return a.distance(dpView{s: s, t: t, pos: pos})
+}
+
+// BackwardTraceBack constructs alignment between s and t based on the result from Backward.
+// It returns the read bases aligned to the variant sites of t.
+func (a *Aligner) BackwardTraceBack(s, t []byte, r Result, pos int) map[int][]byte {
+	return a.traceBack(dpView{s: s, t: t, pos: pos}, r)
+}
+
+//-------------------------------------------------------------------------------------------------
+// Calculate the distance between s and t in forward direction.
+// 	s is a read.
+// 	t is part of a multi-genome starting at pos.
+// The reads include standard bases, the multi-genomes include standard bases and "*" characters.
+//-------------------------------------------------------------------------------------------------
+func (a *Aligner) Forward(s, t []byte, pos int) Result {
+	return a.distance(dpView{s: s, t: t, pos: pos, fwd: true})
+}
+
+// ForwardTraceBack constructs alignment between s and t based on the result from Forward.
+// It returns the read bases aligned to the variant sites of t.
+func (a *Aligner) ForwardTraceBack(s, t []byte, r Result, pos int) map[int][]byte {
+	return a.traceBack(dpView{s: s, t: t, pos: pos, fwd: true}, r)
+}
+
+//-------------------------------------------------------------------------------------------------
+// Calculate the distance between s and t with base qualities.
+// 	q holds the Phred+33 quality of each base of s.
+// Mismatch costs are multiplied by the log-likelihood ratio of a mismatch against a match of the
+// read base, given its error probability (see qualCosts), so that distances are on the Phred scale;
+// DistThres and gap costs should be scaled accordingly. Read bases which disagree with an allele are
+// scored the same way, whatever Config.AlleleMismatches, so that low-quality bases do not make reads
+// unalignable at variant sites. Alignments are traced back with BackwardTraceBack and
+// ForwardTraceBack. It fails if q and s have different lengths.
+//-------------------------------------------------------------------------------------------------
+func (a *Aligner) BackwardQual(s, q, t []byte, pos int) (Result, error) {
+	if err := checkQual(s, q); err != nil {
+		return Result{}, err
+	}
+	r := a.distance(dpView{s: s, q: q, t: t, pos: pos})
+	r.q = q
+	return r, nil
+}
+
+// ForwardQual calculates the distance between s and t with base qualities in forward direction.
+func (a *Aligner) ForwardQual(s, q, t []byte, pos int) (Result, error) {
+	if err := checkQual(s, q); err != nil {
+		return Result{}, err
+	}
+	r := a.distance(dpView{s: s, q: q, t: t, pos: pos, fwd: true})
+	r.q = q
+	return r, nil
+}
+
+// checkQual returns an error if the qualities q are not those of the bases of s.
+func checkQual(s, q []byte) error {
+	if len(q) != len(s) {
+		return fmt.Errorf("%d base qualities for a read of %d bases", len(q), len(s))
+	}
+	return nil
+}
+
+//-------------------------------------------------------------------------------------------------
+// Dynamic programming in both directions.
+// Rows i and columns j count read and genome bases from the free end of the alignment (the start of
+// s and t in backward direction, their end in forward direction); the anchored end is at (M, N).
+//-------------------------------------------------------------------------------------------------
+
+// dpView maps DP rows and columns to the read and the multigenome in either direction.
+type dpView struct {
+	s, t []byte
+	q    []byte // Phred+33 base qualities of s, or nil
+	pos  int
+	fwd  bool
+	win  []int32    // profile site index of each column, -1 if the column is not a variant site
+	circ Contig     // circular contig past whose end t continues at its start, Len 0 if none
+	buf  *Workspace // workspace of win, whose buffers the tracebacks of the view reuse
+}
+
+// window finds the variant sites of the columns of a view, so that the DP does not search the profile.
+// The sites of a genome part which wraps around a circular contig are found piece by piece.
+func (a *Aligner) window(w *Workspace, v *dpView) {
+	w.win = growInt32s(w.win, len(v.t)+1)
+	v.win, v.buf = w.win, w
+	for j := range v.win {
+		v.win[j] = -1
+	}
+	v.circ = a.wrapped(v.pos, len(v.t))
+	p, c := a.profile, v.circ
+	start, off := v.pos, 0 // genome position of t[off]
+	for off < len(v.t) {
+		end := start + len(v.t) - off
+		if c.Len > 0 && end > c.Offset+c.Len {
+			end = c.Offset + c.Len
+		}
+		for k := p.lowerBound(start); k < len(p.sites) && int(p.sites[k]) < end; k++ {
+			col := off + int(p.sites[k]) - start
+			if v.fwd {
+				v.win[len(v.t)-col] = int32(k)
+			} else {
+				v.win[col+1] = int32(k)
+			}
+		}
+		start, off = c.Offset, off+end-start
+	}
+}
+
+// wrapped returns the circular contig whose end is passed by the l genome bases from pos, or a
+// contig of length 0.
+func (a *Aligner) wrapped(pos, l int) Contig {
+	for _, c := range a.circular {
+		if pos >= c.Offset && pos < c.Offset+c.Len && pos+l > c.Offset+c.Len {
+			return c
+		}
+	}
+	return Contig{}
+}
+
+// sameLen returns the common allele length of site k at column j, or 0.
+func (a *Aligner) sameLen(v *dpView, k, j int) int {
+	if a.same_len != nil {
+		return a.same_len[v.gpos(j)]
+	}
+	return a.profile.siteSameLen(k)
+}
+
+// base returns the read base at row i.
+func (v *dpView) base(i int) byte {
+	if v.fwd {
+		return v.s[len(v.s)-i]
+	}
+	return v.s[i-1]
+}
+
+// ref returns the genome character at column j.
+func (v *dpView) ref(j int) byte {
+	if v.fwd {
+		return v.t[len(v.t)-j]
+	}
+	return v.t[j-1]
+}
+
+// gpos returns the genome position of column j, wrapped around the end of a circular contig.
+func (v *dpView) gpos(j int) int {
+	pos := v.pos + j - 1
+	if v.fwd {
+		pos = v.pos + len(v.t) - j
+	}
+	if c := v.circ; c.Len > 0 && pos >= c.Offset+c.Len {
+		pos = c.Offset + (pos-c.Offset)%c.Len
+	}
+	return pos
+}
+
+// offset returns the index in s of the first of the l read bases ending at row i.
+func (v *dpView) offset(i, l int) int {
+	if v.fwd {
+		return len(v.s) - i
+	}
+	return i - l
+}
+
+// seg returns the l read bases ending at row i, in read order.
+func (v *dpView) seg(i, l int) []byte {
+	if v.fwd {
+		return v.s[len(v.s)-i : len(v.s)-i+l]
+	}
+	return v.s[i-l : i]
+}
+
+// qual returns the mismatch weight of the read base at index k of s, see qualCosts.
+func (v *dpView) qual(k int) int {
+	return qualCost(v.q[k])
+}
+
+// sub returns the cost of the read base at row i against a different genome base at column j,
+// weighted by the base quality if there are qualities.
+func (v *dpView) sub(sc Scorer, i, j int) int {
+	c := sc.Sub(v.base(i), v.ref(j))
+	if v.q != nil {
+		if v.fwd {
+			return c * v.qual(len(v.s)-i)
+		}
+		return c * v.qual(i-1)
+	}
+	return c
+}
+
+// allele returns the cost of the read bases ending at row i against an allele. With qualities,
+// read bases which disagree with the allele are scored as mismatches weighted by base quality.
+func (v *dpView) allele(sc Scorer, i int, allele []byte) int {
+	s := v.seg(i, len(allele))
+	if v.q == nil {
+		return sc.Allele(s, allele)
+	}
+	k0 := v.offset(i, len(allele)) // index of s[0] in the read
+	d := 0
+	for k := range s {
+		if s[k] != allele[k] {
+			d += sc.Sub(s[k], allele[k]) * v.qual(k0+k)
+		}
+	}
+	return d
+}
+
+// distance calculates the distance of a view with a new workspace, and gives the alleles chosen by
+// dynamic programming as Result.Trace.
+func (a *Aligner) distance(v dpView) Result {
+	r := a.align(&Workspace{a: a, calls: make(map[int][]byte)}, v)
+	if r.ws != nil {
+		r.Trace = r.ws.traceMatrix(r.M, r.N)
+	}
+	return r
+}
+
+// align calculates the distance of a view with the buffers of a workspace.
+func (a *Aligner) align(w *Workspace, v dpView) Result {
+	w.gapped = a.cfg.gapped()
+	if w.gapped {
+		return a.gappedDistance(w, v)
+	}
+
+	var d, min_d, min_pen, prior int
+	var snp_len int
+	var site, num_alleles int
+	var allele []byte
+	clearCalls(w.calls)
+	var S = w.calls
+	p := a.profile
+	sc := a.scorer()
+	a.window(w, &v)
+
+	var i, j, k int
+	d = 0
+	m, n := len(v.s), len(v.t)
+	// Clipped alignments need the whole read in the DP, which chooses where the alignment starts.
+	clip := a.cfg.clipped()
+	for m > 0 && n > 0 && !clip {
+		site = int(v.win[n])
+		if site < 0 {
+			if v.base(m) != v.ref(n) {
+				d += v.sub(sc, m, n)
+			}
+			m--
+			n--
+		} else if snp_len = a.sameLen(&v, site, n); snp_len != 0 {
+			_, min_d, min_pen = a.bestAllele(&v, sc, site, m)
+			if min_d >= INF {
+				clearCalls(S)
+				return Result{Dist: INF, M: m, N: n, Calls: S, Trace: [][][]byte{}}
+			}
+			S[v.gpos(n)] = v.seg(m, snp_len)
+			d += min_d
+			prior += min_pen
+			m -= snp_len
+			n--
+		} else {
+			break
+		}
+		if d > a.cfg.DistThres {
+			clearCalls(S)
+			return Result{Dist: a.cfg.DistThres + 1, M: m, N: n, Calls: S, Trace: [][][]byte{}}
+		}
+	}
+	// D is stored by rows of n+1 distances, T by rows of n allele indices (see Workspace).
+	w.resize(m, n)
+	D, T := w.D, w.T
+	for j = 0; j <= n; j++ {
+		D[j] = 0
+	}
+	for i = 1; i <= m; i++ {
+		D[i*(n+1)] = INF
+		if a.leadClip(i) {
+			D[i*(n+1)] = a.clipCost(i)
+		}
+	}
+
+	rem := a.cfg.DistThres - d
+	b := a.band(w, &v, m, n, 0, 0)
+	var temp_dis, min_index, row_min, over int
+	var Di, Dp []int // rows i and i-1
+	for i = 1; i <= m; i++ {
+		Di, Dp = D[i*(n+1):(i+1)*(n+1)], D[(i-1)*(n+1):i*(n+1)]
+		for j = 1; j < b.lo[i] && j <= n; j++ {
+			Di[j] = 1000 * INF
+		}
+		for j = b.hi[i] + 1; j <= n; j++ {
+			Di[j] = 1000 * INF
+		}
+		row_min = Di[0]
+		for j = b.lo[i]; j <= b.hi[i]; j++ {
+			site = int(v.win[j])
+			if site < 0 {
+				if v.base(i) != v.ref(j) {
+					Di[j] = Dp[j-1] + v.sub(sc, i, j)
+				} else {
+					Di[j] = Dp[j-1]
+				}
+				if clip {
+					T[(i-1)*n+j-1] = 0
+				}
+			} else {
+				Di[j] = 1000 * INF //1000*INF is a value for testing, will change to a better solution later
+				min_index = 0
+				num_alleles = p.numAlleles(site)
+				for k = 0; k < num_alleles; k++ {
+					allele = p.allele(site, k)
+					snp_len = len(allele)
+					//One possible case: i - snp_len < 0 for all k
+					if i-snp_len >= 0 {
+						if allele[0] != '.' {
+							temp_dis = D[(i-snp_len)*(n+1)+j-1] + v.allele(sc, i, allele)
+						} else {
+							temp_dis = Di[j-1]
+						}
+						temp_dis += a.pen(site, k)
+						if Di[j] > temp_dis || (Di[j] == temp_dis && a.prefer(site, k, min_index)) {
+							Di[j] = temp_dis
+							min_index = k
+						}
+					}
+				}
+				T[(i-1)*n+j-1] = uint16(min_index + 1)
+			}
+			// The alignment may start at the cell after clipping the read bases of rows 1..i.
+			if clip && a.clipCost(i) < Di[j] {
+				Di[j] = a.clipCost(i)
+				T[(i-1)*n+j-1] = tclip
+			}
+			if Di[j] < row_min {
+				row_min = Di[j]
+			}
+		}
+		if a.abandon(b, row_min, rem, &over) {
+			return a.abandoned(w, m, n)
+		}
+	}
+	end_i, end_j, dist := m, n, D[m*(n+1)+n]
+	if a.cfg.Mode == ModeLocal {
+		end_i, end_j, dist = a.clipEnd(D, m, n)
+	}
+	if dist >= INF {
+		return Result{Dist: 0, DPDist: INF, M: m, N: n, Calls: S, Trace: [][][]byte{}, OK: true}
+	}
+	r := Result{Dist: d, DPDist: dist, M: m, N: n, Calls: S, ws: w, OK: true, AnchorClip: m - end_i, skip: n - end_j}
+	if a.priors() || clip {
+		r.Prior, r.FreeClip = a.trace(v, r, nil)
+		r.Prior += prior
+	}
+	return r
+}
+
+func (a *Aligner) traceBack(v dpView, r Result) map[int][]byte {
+	a.window(&Workspace{}, &v)
+	snp_calling := make(map[int][]byte)
+	a.trace(v, r, snp_calling)
+	return snp_calling
+}
+
+// bestAllele returns the allele of smallest cost (with its prior penalty) for the read bases ending
+// at row i at a same-length variant site, as chosen without dynamic programming, its cost and its
+// prior penalty.
+func (a *Aligner) bestAllele(v *dpView, sc Scorer, site, i int) (int, int, int) {
+	min_d, min_pen, min_index := 1000*INF, 0, 0 // 1000*INF is a value for testing, will change to a better solution later
+	for k := 0; k < a.profile.numAlleles(site); k++ {
+		pen := a.pen(site, k)
+		cost := v.allele(sc, i, a.profile.allele(site, k)) + pen
+		if min_d > cost || (min_d == cost && a.prefer(site, k, min_index)) {
+			min_d, min_pen, min_index = cost, pen, k
+		}
+	}
+	return min_index, min_d, min_pen
+}
+
+// alleleIndex returns the index of the allele chosen by dynamic programming at the variant site of
+// cell (i, j).
+func (r *Result) alleleIndex(p *Profile, v *dpView, i, j int) int {
+	if r.ws == nil {
+		return p.alleleIndex(int(v.win[j]), r.Trace[i-1][j-1])
+	}
+	return int(r.ws.T[(i-1)*r.N+j-1]) - 1
+}
+
+// allele returns the allele chosen by dynamic programming at the variant site of cell (i, j).
+func (r *Result) allele(p *Profile, v *dpView, i, j int) []byte {
+	if r.ws == nil {
+		return r.Trace[i-1][j-1]
+	}
+	return p.allele(int(v.win[j]), int(r.ws.T[(i-1)*r.N+j-1])-1)
+}
+
+// trace adds the read bases aligned to the variant sites to snp_calling, if it is not nil, and
+// returns the sum of the prior penalties of the alleles chosen by dynamic programming and the
+// number of read bases soft-clipped at the free end.
+func (a *Aligner) trace(v dpView, r Result, snp_calling map[int][]byte) (int, int) {
+	if r.ws != nil && r.ws.gapped {
+		return a.gappedTraceBack(v, r, snp_calling)
+	}
+	var snp_len, prior int
+	var allele []byte
+
+	if snp_calling != nil {
+		for k, val := range r.Calls {
+			snp_calling[k] = val
+		}
+	}
+	i, j := r.end()
+	for i > 0 || j > 0 {
+		if i > 0 && a.clipStart(&r, i, j) {
+			return prior, i
+		}
+		if i > 0 && j > 0 {
+			if v.win[j] < 0 {
+				i, j = i-1, j-1
+			} else {
+				allele = r.allele(a.profile, &v, i, j)
+				if allele[0] != '.' {
+					snp_len = len(allele)
+				} else {
+					snp_len = 0
+				}
+				if snp_calling != nil {
+					snp_calling[v.gpos(j)] = v.seg(i, snp_len)
+				}
+				prior += a.tracePen(int(v.win[j]), allele)
+				i, j = i-snp_len, j-1
+			}
+		} else if i == 0 {
+			j = j - 1
+		} else if j == 0 {
+			i = i - 1
+		}
+	}
+	return prior, 0
+}
@@ -0,0 +1,163 @@
+//----------------------------------------------------------------------------------------
+// Test for aligners
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// Test for alignment with aligner instances
+func TestAlignerBackwardForward(t *testing.T) {
+	defer __(o_())
+
+	var test_cases = []TestCase{
+		{type_snpprofile{3: {{'A'}, {'C'}}}, type_samelensnp{3: 1}, "ACC*CGT", "ACCACGT", 0},
+		{type_snpprofile{3: {{'A'}, {'C'}}}, type_samelensnp{3: 1}, "ACC*CGT", "ACCTCGT", INF},
+		{type_snpprofile{3: {{'A'}, {'C'}, {'.'}}}, type_samelensnp{}, "ACC*CGT", "ACCCGT", 0},
+		{type_snpprofile{3: {{'T'}, {'T', 'T', 'A'}}}, type_samelensnp{}, "ACC*CGT", "ACCTTACGT", 0},
+		{type_snpprofile{0: {{'A'}, {'T', 'A'}, {'T', 'T', 'A'}, {'.'}}}, type_samelensnp{}, "*ACGT", "TTAACGT", 0},
+	}
+	for i, tc := range test_cases {
+		a := NewProfileAligner(NewProfile(tc.Profile), DefaultConfig())
+		read, genome := []byte(tc.read), []byte(tc.genome)
+		r := a.Backward(read, genome, 0)
+		if r.Distance() != tc.d {
+			t.Errorf("Fail backward alignment (case %d, read %s, genome %s): got %d, want %d", i, tc.read, tc.genome, r.Distance(), tc.d)
+		} else if r.Distance() < INF {
+			fmt.Println(i, r.Distance(), a.BackwardTraceBack(read, genome, r, 0))
+		}
+		r = a.Forward(read, genome, 0)
+		if r.Distance() != tc.d {
+			t.Errorf("Fail forward alignment (case %d, read %s, genome %s): got %d, want %d", i, tc.read, tc.genome, r.Distance(), tc.d)
+		} else if r.Distance() < INF {
+			fmt.Println(i, r.Distance(), a.ForwardTraceBack(read, genome, r, 0))
+		}
+	}
+}
+
+// Test for aligners with different profiles and thresholds used at the same time
+func TestAlignerConcurrent(t *testing.T) {
+	defer __(o_())
+
+	genome := []byte("ACC*CGTACGTTAC")
+	read := []byte("ACCACGTACGTAAC")
+	a1 := NewProfileAligner(NewProfile(type_snpprofile{3: {{'A'}, {'C'}}}), DefaultConfig())
+	a2 := NewProfileAligner(NewProfile(type_snpprofile{3: {{'T'}, {'C'}}}), DefaultConfig())
+	a3 := NewProfileAligner(NewProfile(type_snpprofile{3: {{'A'}, {'C'}}}), Config{DistThres: 0})
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 300)
+	for g := 0; g < 100; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r := a1.Backward(read, genome, 0); r.Distance() != 1 {
+				errs <- fmt.Sprintf("a1: got %d, want 1", r.Distance())
+			}
+			if r := a2.Backward(read, genome, 0); r.Distance() != INF {
+				errs <- fmt.Sprintf("a2: got %d, want INF", r.Distance())
+			}
+			if r := a3.Backward(read, genome, 0); r.Distance() != 1 || r.OK {
+				errs <- fmt.Sprintf("a3: got %d %v, want 1 false", r.Distance(), r.OK)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		t.Error(e)
+	}
+}
+
+//----------------------------------------------------------------------------------------
+// Benchmarks on the SNP profile of the bundled chr1 test data, with a random genome.
+//----------------------------------------------------------------------------------------
+
+var bench_once sync.Once
+var bench_genome []byte
+var bench_profile map[int][][]byte
+var bench_same_len map[int]int
+
+func benchData() ([]byte, map[int][][]byte, map[int]int) {
+	bench_once.Do(func() {
+		bench_profile, bench_same_len = LoadSNPLocation("test_data/SNPLocation.txt")
+		n := 0
+		for pos := range bench_profile {
+			if pos >= n {
+				n = pos + 1
+			}
+		}
+		rnd := rand.New(rand.NewSource(1))
+		bench_genome = make([]byte, n+1000)
+		for i := range bench_genome {
+			bench_genome[i] = "ACGT"[rnd.Intn(4)]
+		}
+		for pos := range bench_profile {
+			bench_genome[pos] = '*'
+		}
+	})
+	return bench_genome, bench_profile, bench_same_len
+}
+
+// benchRead realizes a multigenome segment with the last allele of each site.
+func benchRead(t []byte, pos int, profile map[int][][]byte) []byte {
+	var read []byte
+	for j, c := range t {
+		if alleles, ok := profile[pos+j]; ok {
+			if a := alleles[len(alleles)-1]; a[0] != '.' {
+				read = append(read, a...)
+			}
+		} else {
+			read = append(read, c)
+		}
+	}
+	return read
+}
+
+// Full DP: the anchored end of the alignment is the indel site at 146.
+func BenchmarkBackwardDP(b *testing.B) {
+	genome, profile, same_len := benchData()
+	t := genome[0:147]
+	read := benchRead(t, 0, profile)
+	read = read[len(read)-100:]
+	Init(INF, profile, same_len, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		BackwardDistanceMulti(read, t, 0)
+	}
+}
+
+func BenchmarkForwardDP(b *testing.B) {
+	genome, profile, same_len := benchData()
+	t := genome[146:296]
+	read := benchRead(t, 146, profile)[:100]
+	Init(INF, profile, same_len, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ForwardDistanceMulti(read, t, 146)
+	}
+}
+
+// Reads at random positions of the genome, mostly aligned without DP.
+func BenchmarkBackwardRandom(b *testing.B) {
+	genome, profile, same_len := benchData()
+	rnd := rand.New(rand.NewSource(2))
+	pos := make([]int, 1000)
+	reads := make([][]byte, len(pos))
+	for k := range pos {
+		pos[k] = rnd.Intn(len(genome) - 120)
+		reads[k] = benchRead(genome[pos[k]:pos[k]+110], pos[k], profile)[:100]
+	}
+	Init(INF, profile, same_len, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := i % len(pos)
+		BackwardDistanceMulti(reads[k], genome[pos[k]:pos[k]+110], pos[k])
+	}
+}
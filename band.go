@@ -0,0 +1,129 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: banded dynamic programming module.
+// Bands of the DP matrices derived from the distance threshold, and early abandonment of alignments
+// whose distance exceeds it, so that rejected candidate positions cost little.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+// dpBand holds the columns lo[i]..hi[i] of each row i of a DP matrix which can be on an alignment
+// within the distance threshold; other cells are not computed. The row is empty if lo[i] > hi[i].
+//
+// An alignment from cell (i, j) to the anchored end (m, n) consumes m-i read bases for n-j genome
+// bases. The difference is made by the indel alleles of the variant sites of columns j+1..n, and
+// by at most gi insertions and gd deletions outside variant sites, so (m-i)-(n-j) lies between
+// low[j]-gd and high[j]+gi, where low[j] and high[j] sum the shortest and longest allele length
+// minus 1 of these sites (0 for the deletion allele ".").
+//
+// Soft-clipping the free end of the read does not change this, but local alignments may end before
+// (m, n), so their band holds whole rows.
+type dpBand struct {
+	lo, hi []int
+	rows   int // the largest number of rows crossed by an allele, at least 1
+}
+
+// band returns the band of the DP of a view with m rows and n columns, allowing gi insertions and
+// gd deletions outside variant sites. Without them the band holds the cells which can reach the
+// anchored end at all, so the DP gives the same distance as without band.
+func (a *Aligner) band(w *Workspace, v *dpView, m, n, gi, gd int) dpBand {
+	p := a.profile
+	w.lo, w.hi = growInts(w.lo, m+1), growInts(w.hi, m+1)
+	w.low, w.high = growInts(w.low, n+1), growInts(w.high, n+1)
+	b := dpBand{lo: w.lo, hi: w.hi, rows: 1}
+	low, high := w.low, w.high
+	low[n], high[n] = 0, 0
+	for j := n; j >= 1; j-- {
+		low[j-1], high[j-1] = low[j], high[j]
+		site := int(v.win[j])
+		if site < 0 {
+			continue
+		}
+		min_len, max_len := INF, 0
+		for k := 0; k < p.numAlleles(site); k++ {
+			l := len(p.allele(site, k))
+			if p.allele(site, k)[0] == '.' {
+				l = 0
+			}
+			if l < min_len {
+				min_len = l
+			}
+			if l > max_len {
+				max_len = l
+			}
+		}
+		low[j-1] += min_len - 1
+		high[j-1] += max_len - 1
+		if max_len > b.rows {
+			b.rows = max_len
+		}
+	}
+	// j-low[j] and j-high[j] do not decrease with j, so the columns of a row are contiguous and
+	// the bounds of the rows do not decrease with i.
+	lo, hi := 1, 0
+	if a.cfg.Mode == ModeLocal {
+		lo, hi = 1, n
+		for i := 1; i <= m; i++ {
+			b.lo[i], b.hi[i] = lo, hi
+		}
+		return b
+	}
+	for i := 1; i <= m; i++ {
+		for lo <= n && lo-low[lo] < n-m+i-gd {
+			lo++
+		}
+		for hi < n && hi+1-high[hi+1] <= n-m+i+gi {
+			hi++
+		}
+		b.lo[i], b.hi[i] = lo, hi
+	}
+	return b
+}
+
+// gaps returns the largest number of read bases which can be inserted, and of genome bases which
+// can be deleted, outside variant sites by an alignment of distance at most rem, see Config.Ins
+// and Config.Del. It returns m and n if the aligner has no distance threshold.
+func (a *Aligner) gaps(rem, m, n int) (int, int) {
+	if a.cfg.DistThres >= INF {
+		return m, n
+	}
+	gi, gd := 0, 0
+	if a.cfg.Ins > 0 {
+		gi = rem / a.cfg.Ins
+	}
+	if a.cfg.Del > 0 {
+		gd = rem / a.cfg.Del
+	}
+	if gi > m {
+		gi = m
+	}
+	if gd > n {
+		gd = n
+	}
+	return gi, gd
+}
+
+// abandon returns whether the alignment exceeds the remaining distance rem, knowing the smallest
+// distance of a row and the number of rows over rem just before it (which it updates): every path
+// to the anchored end crosses b.rows consecutive rows, so it does once they are all over rem.
+// Alignments which start by soft-clipping the read after these rows are covered too: clipping
+// more bases costs more, and clipping up to these rows is one of their distances, over rem.
+// Alignments are not abandoned if the aligner has no distance threshold, or if they are local, as
+// these may end before the rows.
+func (a *Aligner) abandon(b dpBand, row_min, rem int, over *int) bool {
+	if a.cfg.DistThres >= INF || a.cfg.Mode == ModeLocal {
+		return false
+	}
+	if row_min <= rem {
+		*over = 0
+		return false
+	}
+	*over++
+	return *over >= b.rows
+}
+
+// abandoned returns the result of an alignment abandoned by dynamic programming.
+func (a *Aligner) abandoned(w *Workspace, m, n int) Result {
+	clearCalls(w.calls)
+	return Result{Dist: a.cfg.DistThres + 1, M: m, N: n, Calls: w.calls, Trace: [][][]byte{}}
+}
@@ -0,0 +1,131 @@
+//----------------------------------------------------------------------------------------
+// Test for banded dynamic programming
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// bandData returns a random multigenome segment with SNP and indel sites, and reads realized from
+// it with random alleles, mismatches and indels outside the variant sites.
+func bandData(rnd *rand.Rand) ([]byte, type_snpprofile, [][]byte) {
+	genome := make([]byte, 60)
+	for i := range genome {
+		genome[i] = "ACGT"[rnd.Intn(4)]
+	}
+	profile := type_snpprofile{
+		7:  {{'A'}, {'C'}},
+		15: {{'.'}, {'G'}, {'G', 'T'}},
+		22: {{'T'}, {'T', 'A', 'C'}},
+		40: {{'A'}, {'.'}},
+		47: {{'C'}, {'G'}, {'T'}},
+	}
+	for pos := range profile {
+		genome[pos] = '*'
+	}
+	var reads [][]byte
+	for k := 0; k < 200; k++ {
+		var read []byte
+		for j, c := range genome {
+			if alleles, ok := profile[j]; ok {
+				if a := alleles[rnd.Intn(len(alleles))]; a[0] != '.' {
+					read = append(read, a...)
+				}
+				continue
+			}
+			switch x := rnd.Intn(40); {
+			case x == 0:
+				read = append(read, "ACGT"[rnd.Intn(4)])
+			case x == 1:
+				read = append(read, c, "ACGT"[rnd.Intn(4)])
+			case x == 2:
+			default:
+				read = append(read, c)
+			}
+		}
+		reads = append(reads, read)
+	}
+	return genome, profile, reads
+}
+
+// Alignments within the distance threshold are the same with and without threshold, others exceed it.
+func TestAlignerBand(t *testing.T) {
+	defer __(o_())
+
+	genome, profile, reads := bandData(rand.New(rand.NewSource(3)))
+	p := NewProfile(profile)
+	abandoned := 0
+	for _, cfg := range []Config{{}, {Ins: 1, Del: 1}, {Ins: 2, Del: 1, GapOpen: 2}} {
+		cfg.DistThres = INF
+		a := NewProfileAligner(p, cfg)
+		for thres := 0; thres <= 6; thres++ {
+			cfg.DistThres = thres
+			b := NewProfileAligner(p, cfg)
+			for k, read := range reads {
+				for _, fwd := range []bool{false, true} {
+					var r1, r2 Result
+					var s1, s2 map[int][]byte
+					if fwd {
+						r1, r2 = a.Forward(read, genome, 0), b.Forward(read, genome, 0)
+					} else {
+						r1, r2 = a.Backward(read, genome, 0), b.Backward(read, genome, 0)
+					}
+					if r1.Distance() > thres {
+						if r2.Distance() <= thres {
+							t.Errorf("Fail exceeding threshold (config %v, read %d, fwd %v): got %d, want %d", cfg, k, fwd, r2.Distance(), r1.Distance())
+						}
+						if !r2.OK {
+							abandoned++
+						}
+						continue
+					}
+					if fwd {
+						s1, s2 = a.ForwardTraceBack(read, genome, r1, 0), b.ForwardTraceBack(read, genome, r2, 0)
+					} else {
+						s1, s2 = a.BackwardTraceBack(read, genome, r1, 0), b.BackwardTraceBack(read, genome, r2, 0)
+					}
+					if r2.Distance() != r1.Distance() || fmt.Sprint(s2) != fmt.Sprint(s1) {
+						t.Errorf("Fail banded alignment (config %v, read %d, fwd %v): got %d %v, want %d %v", cfg, k, fwd, r2.Distance(), s2, r1.Distance(), s1)
+					}
+				}
+			}
+		}
+	}
+	if abandoned == 0 {
+		t.Errorf("Fail abandoning alignments")
+	}
+	fmt.Println("abandoned alignments:", abandoned)
+}
+
+// Reads aligned to random positions of the genome, rejected by a distance threshold.
+func BenchmarkBackwardReject(b *testing.B) {
+	benchReject(b, 5)
+}
+
+func BenchmarkBackwardRejectNoThres(b *testing.B) {
+	benchReject(b, INF)
+}
+
+func benchReject(b *testing.B, thres int) {
+	genome, profile, _ := benchData()
+	p := NewProfile(profile)
+	rnd := rand.New(rand.NewSource(4))
+	pos := make([]int, 1000)
+	reads := make([][]byte, len(pos))
+	for k := range pos {
+		pos[k] = rnd.Intn(len(genome) - 120)
+		reads[k] = benchRead(genome[pos[k]:pos[k]+110], pos[k], profile)[:100]
+	}
+	a := NewProfileAligner(p, Config{DistThres: thres, Ins: 1, Del: 1})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := i % len(pos)
+		l := (k + 1) % len(pos)
+		a.Backward(reads[k], genome[pos[l]:pos[l]+110], pos[l])
+	}
+}
@@ -0,0 +1,201 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: sparse bitvector module.
+// Elias-Fano encoded sets of positions with rank and select, used to mark the "*" sites of packed
+// multigenomes in about 2 + log2(n/m) bits per site.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"math/bits"
+	"sort"
+)
+
+const (
+	efSample = 256           // one select sample every efSample ones (zeros) of the upper bits
+	efSpan   = 64 * efSample // blocks of efSample ones (zeros) spanning more bits keep their positions
+	efSuper  = 512           // bits of the upper bits per entry of the rank directory
+)
+
+// sparseBitvector is a bitvector of length n with m ones, stored as the Elias-Fano encoding of the
+// positions of the ones: the low l bits of each position in a packed array, the high bits in unary.
+// Select on the high bits takes constant time with a rank directory and select directories, as in
+// Clark's select: dense blocks are scanned through the rank directory, sparse ones are listed.
+type sparseBitvector struct {
+	n, m  int
+	l     uint
+	low   []uint64 // m entries of l bits
+	high  []uint64 // bit (p_k >> l) + k is set for the k-th one p_k
+	nhigh int      // number of bits in high
+	rank  []int    // number of ones in high before every efSuper-th bit, and in all of high
+	sel1  selectDir
+	sel0  selectDir
+}
+
+// selectDir is a select directory of the ones (zeros) of the high bits of a sparse bitvector.
+type selectDir struct {
+	sample []int // position of every efSample-th one (zero)
+	sparse []int // offset in pos of the positions of each block which spans more than efSpan bits, or -1
+	pos    []int // positions of the ones (zeros) of sparse blocks
+}
+
+// newSparseBitvector creates a bitvector of length n with ones at the given increasing positions.
+func newSparseBitvector(n int, ones []int) *sparseBitvector {
+	b := &sparseBitvector{n: n, m: len(ones)}
+	if b.m > 0 && n/b.m > 1 {
+		b.l = uint(bits.Len(uint(n/b.m)) - 1)
+	}
+	b.low = make([]uint64, (b.m*int(b.l)+63)/64+1)
+	b.nhigh = b.m + n>>b.l + 1
+	b.high = make([]uint64, (b.nhigh+63)/64)
+	for k, p := range ones {
+		b.setLow(k, uint64(p)&(1<<b.l-1))
+		h := p>>b.l + k
+		b.high[h/64] |= 1 << uint(h%64)
+	}
+	b.index()
+	return b
+}
+
+// index builds the rank and select directories of the high bits.
+func (b *sparseBitvector) index() {
+	nsuper := (b.nhigh + efSuper - 1) / efSuper
+	b.rank = make([]int, nsuper+1)
+	for s := 0; s < nsuper; s++ {
+		c := 0
+		for w := s * efSuper / 64; w < (s+1)*efSuper/64 && w < len(b.high); w++ {
+			c += bits.OnesCount64(b.high[w])
+		}
+		b.rank[s+1] = b.rank[s] + c
+	}
+	b.sel1 = b.selectDir(true)
+	b.sel0 = b.selectDir(false)
+}
+
+// selectDir builds the select directory of the ones (if one) or zeros (if !one) of the high bits.
+func (b *sparseBitvector) selectDir(one bool) selectDir {
+	var d selectDir
+	seen := 0
+	for i := 0; i < b.nhigh; i++ {
+		if b.bit(i) == one {
+			if seen%efSample == 0 {
+				d.sample = append(d.sample, i)
+			}
+			seen++
+		}
+	}
+	d.sparse = make([]int, len(d.sample))
+	for k, i := range d.sample {
+		end := b.nhigh
+		if k+1 < len(d.sample) {
+			end = d.sample[k+1]
+		}
+		d.sparse[k] = -1
+		if end-i > efSpan {
+			d.sparse[k] = len(d.pos)
+			for ; i < end; i++ {
+				if b.bit(i) == one {
+					d.pos = append(d.pos, i)
+				}
+			}
+		}
+	}
+	return d
+}
+
+func (b *sparseBitvector) bit(i int) bool {
+	return b.high[i/64]>>uint(i%64)&1 == 1
+}
+
+func (b *sparseBitvector) setLow(k int, v uint64) {
+	if b.l == 0 {
+		return
+	}
+	i := k * int(b.l)
+	b.low[i/64] |= v << uint(i%64)
+	if i%64+int(b.l) > 64 {
+		b.low[i/64+1] |= v >> uint(64-i%64)
+	}
+}
+
+func (b *sparseBitvector) getLow(k int) uint64 {
+	if b.l == 0 {
+		return 0
+	}
+	i := k * int(b.l)
+	v := b.low[i/64] >> uint(i%64)
+	if i%64+int(b.l) > 64 {
+		v |= b.low[i/64+1] << uint(64-i%64)
+	}
+	return v & (1<<b.l - 1)
+}
+
+// selectHigh returns the position in high of the k-th one (if one) or zero (if !one), k >= 0.
+func (b *sparseBitvector) selectHigh(k int, one bool) int {
+	d := &b.sel0
+	if one {
+		d = &b.sel1
+	}
+	if off := d.sparse[k/efSample]; off >= 0 {
+		return d.pos[off+k%efSample]
+	}
+	// The block spans at most efSpan bits: find the superblock of the bit, then its word.
+	count := func(s int) int { // number of ones (zeros) before superblock s
+		if one {
+			return b.rank[s]
+		}
+		return s*efSuper - b.rank[s]
+	}
+	s := d.sample[k/efSample] / efSuper
+	for s+1 < len(b.rank) && count(s+1) <= k {
+		s++
+	}
+	k -= count(s)
+	for w := s * efSuper / 64; ; w++ {
+		x := b.high[w]
+		if !one {
+			x = ^x
+		}
+		if c := bits.OnesCount64(x); c > k {
+			for ; k > 0; k-- {
+				x &= x - 1
+			}
+			return 64*w + bits.TrailingZeros64(x)
+		}
+		k -= bits.OnesCount64(x)
+	}
+}
+
+// Select returns the position of the k-th one.
+func (b *sparseBitvector) Select(k int) int {
+	return (b.selectHigh(k, true)-k)<<b.l | int(b.getLow(k))
+}
+
+// Rank returns the number of ones before position i, and whether position i is a one.
+// The ones with the high bits of i are found by binary search on their low bits.
+func (b *sparseBitvector) Rank(i int) (int, bool) {
+	if i < 0 {
+		return 0, false
+	}
+	if i >= b.n {
+		return b.m, false
+	}
+	h, il := i>>b.l, uint64(i)&(1<<b.l-1)
+	lo := 0 // index of the first one with high bits h
+	if h > 0 {
+		lo = b.selectHigh(h-1, false) + 1 - h
+	}
+	hi := b.selectHigh(h, false) - h
+	k := lo + sort.Search(hi-lo, func(j int) bool { return b.getLow(lo+j) >= il })
+	return k, k < hi && b.getLow(k) == il
+}
+
+// Size returns the memory used by the bitvector in bytes.
+func (b *sparseBitvector) Size() int {
+	return 8*(len(b.low)+len(b.high)+len(b.rank)) + b.sel0.size() + b.sel1.size()
+}
+
+func (d *selectDir) size() int {
+	return 8 * (len(d.sample) + len(d.sparse) + len(d.pos))
+}
@@ -0,0 +1,306 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: reference alignment module.
+// Alignments of reads to the linear reference, with the alleles chosen at the variant sites
+// expanded, as CIGAR strings with MD and NM tags for SAM output.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"strconv"
+)
+
+// Alignment is the alignment of a read to the linear reference. Each variant site stands for one
+// reference base, the first base of its reference allele ('N' if the reference allele is not
+// known, see Profile.RefAllele). The read bases aligned to a site are a match or mismatch of their
+// first base followed by an insertion of the others, or a deletion if there are none. Read bases
+// soft-clipped by the aligner (see Config.Mode) are S operations at the ends of the CIGAR string.
+type Alignment struct {
+	Pos   int    // genome position of the first reference base of the alignment, -1 if none
+	Cigar string // CIGAR string with M, I, D and S operations, "*" if the read is not aligned
+	MD    string // SAM MD tag: the reference bases of mismatches and deletions
+	NM    int    // SAM NM tag: the number of mismatches, inserted and deleted bases
+}
+
+// BackwardAlignment returns the alignment of s to the linear reference from the result of Backward.
+func (a *Aligner) BackwardAlignment(s, t []byte, r Result, pos int) Alignment {
+	return a.alignment(dpView{s: s, t: t, pos: pos}, r)
+}
+
+// ForwardAlignment returns the alignment of s to the linear reference from the result of Forward.
+func (a *Aligner) ForwardAlignment(s, t []byte, r Result, pos int) Alignment {
+	return a.alignment(dpView{s: s, t: t, pos: pos, fwd: true}, r)
+}
+
+// alnCol is a column of an alignment to the linear reference: a read base aligned to a reference
+// base (op 'M'), a read base which is not in the reference ('I'), a reference base which is not in
+// the read ('D'), or a soft-clipped read base ('S'). pos is the genome position of the reference base.
+type alnCol struct {
+	op, read, ref byte
+	pos           int
+}
+
+// alnBuilder collects the columns of an alignment while the DP is traced back from the anchored
+// end, that is from right to left in backward direction and from left to right in forward direction.
+// It also collects the evidence at the variant sites if evidence is set.
+type alnBuilder struct {
+	a        *Aligner
+	v        *dpView
+	cols     []alnCol
+	evidence bool
+	dp       bool // whether the columns are aligned by dynamic programming
+	calls    []SiteCall
+}
+
+// unit adds the columns of a DP step, given in reference order.
+func (b *alnBuilder) unit(cols ...alnCol) {
+	if b.v.fwd {
+		b.cols = append(b.cols, cols...)
+		return
+	}
+	for k := len(cols) - 1; k >= 0; k-- {
+		b.cols = append(b.cols, cols[k])
+	}
+}
+
+// refBase returns the reference base of the site at column j, and false if it is not known.
+func (b *alnBuilder) refBase(j int) (byte, bool) {
+	p, k := b.a.profile, int(b.v.win[j])
+	if ref := p.siteRef(k); ref >= 0 && p.allele(k, ref)[0] != '.' {
+		return p.allele(k, ref)[0], true
+	}
+	return 'N', false
+}
+
+// match adds the read base of row i aligned to the genome base of column j, which is not a site.
+func (b *alnBuilder) match(i, j int) {
+	b.unit(alnCol{op: opMatch, read: b.v.base(i), ref: b.v.ref(j), pos: b.v.gpos(j)})
+}
+
+// ins adds the read base of row i as an insertion.
+func (b *alnBuilder) ins(i int) {
+	b.unit(alnCol{op: opIns, read: b.v.base(i)})
+}
+
+// del adds the genome base of column j, or the reference base of its site, as a deletion.
+func (b *alnBuilder) del(j int) {
+	if b.v.win[j] >= 0 {
+		ref, _ := b.refBase(j)
+		b.unit(alnCol{op: opDel, ref: ref, pos: b.v.gpos(j)})
+	} else {
+		b.unit(alnCol{op: opDel, ref: b.v.ref(j), pos: b.v.gpos(j)})
+	}
+}
+
+// clip adds the read base of row i as soft-clipped.
+func (b *alnBuilder) clip(i int) {
+	b.unit(alnCol{op: opClip, read: b.v.base(i)})
+}
+
+// call adds the evidence of the l read bases ending at row i aligned to the k-th allele of the
+// site of column j.
+func (b *alnBuilder) call(i, j, l, k int) {
+	if !b.evidence {
+		return
+	}
+	site := int(b.v.win[j])
+	b.calls = append(b.calls, SiteCall{Pos: b.v.gpos(j), Allele: k, ID: b.a.profile.alleleID(site, k),
+		ReadPos: b.v.offset(i, l), Len: l, DP: b.dp})
+}
+
+// site adds the l read bases ending at row i aligned to the k-th allele of the site of column j.
+func (b *alnBuilder) site(i, j, l, k int) {
+	b.call(i, j, l, k)
+	if l == 0 {
+		b.del(j)
+		return
+	}
+	seg, pos := b.v.seg(i, l), b.v.gpos(j)
+	cols := make([]alnCol, l)
+	// A site whose reference allele is not known, as in profiles without REF alleles, is not
+	// counted as a mismatch.
+	ref, ok := b.refBase(j)
+	if !ok {
+		ref = seg[0]
+	}
+	cols[0] = alnCol{op: opMatch, read: seg[0], ref: ref, pos: pos}
+	for k := 1; k < l; k++ {
+		cols[k] = alnCol{op: opIns, read: seg[k]}
+	}
+	b.unit(cols...)
+}
+
+func (a *Aligner) alignment(v dpView, r Result) Alignment {
+	cols, ok := a.alignCols(v, r)
+	if !ok {
+		return Alignment{Pos: -1, Cigar: "*"}
+	}
+	return newAlignment(cols)
+}
+
+// alignCols returns the columns of an alignment in reference order, and false if the read is not
+// aligned.
+func (a *Aligner) alignCols(v dpView, r Result) ([]alnCol, bool) {
+	b := &alnBuilder{a: a}
+	ok := a.follow(b, v, r)
+	return b.cols, ok
+}
+
+// follow adds the columns of an alignment to a builder, in reference order, and returns false if
+// the read is not aligned. It follows the alignment from its anchored end: the part aligned without
+// dynamic programming (as in distance), then the DP matrices (as in trace and gappedTraceBack).
+func (a *Aligner) follow(b *alnBuilder, v dpView, r Result) bool {
+	if !r.OK || r.Distance() >= INF {
+		return false
+	}
+	v.q = r.q
+	a.window(&Workspace{}, &v)
+	b.v = &v
+	sc := a.scorer()
+	m, n := len(v.s), len(v.t)
+	for n > r.N {
+		if site := int(v.win[n]); site < 0 {
+			b.match(m, n)
+			m, n = m-1, n-1
+		} else {
+			l := a.sameLen(&v, site, n)
+			k, _, _ := a.bestAllele(&v, sc, site, m)
+			b.site(m, n, l, k)
+			m, n = m-l, n-1
+		}
+	}
+	b.dp = true
+	i, j := r.M, r.N
+	for ; i > r.M-r.AnchorClip; i-- {
+		b.clip(i)
+	}
+	j -= r.skip
+	if r.ws != nil && r.ws.gapped {
+		i, j = a.gappedPath(b, r)
+	} else {
+		for i > 0 && j > 0 && !a.clipStart(&r, i, j) {
+			if v.win[j] < 0 {
+				b.match(i, j)
+				i, j = i-1, j-1
+				continue
+			}
+			allele := r.allele(a.profile, &v, i, j)
+			l := len(allele)
+			if allele[0] == '.' {
+				l = 0
+			}
+			b.site(i, j, l, r.alleleIndex(a.profile, &v, i, j))
+			i, j = i-l, j-1
+		}
+	}
+	// Read bases left over at the free end are soft-clipped or insertions.
+	for ; i > 0; i-- {
+		if r.FreeClip > 0 {
+			b.clip(i)
+		} else {
+			b.ins(i)
+		}
+	}
+	if !v.fwd {
+		for x, y := 0, len(b.cols)-1; x < y; x, y = x+1, y-1 {
+			b.cols[x], b.cols[y] = b.cols[y], b.cols[x]
+		}
+	}
+	return true
+}
+
+// gappedPath follows the edit operations of a gapped alignment, and the columns aligned by the
+// bit-parallel kernel with runPath, and returns the cell where it stops.
+func (a *Aligner) gappedPath(b *alnBuilder, r Result) (int, int) {
+	v := b.v
+	O, X := r.ws.O, r.ws.X
+	op := byte(opMatch)
+	i, j := r.end()
+	for i > 0 && j > 0 {
+		if op == opMatch {
+			if s := r.runStart(j); s > 0 {
+				i, j = a.runPath(b, v, r, s, i, j)
+				continue
+			}
+			op = O[(i-1)*r.N+j-1]
+		}
+		switch op {
+		case opClip:
+			return i, j
+		case opIns:
+			b.ins(i)
+			if X[(i-1)*r.N+j-1]&extIns == 0 {
+				op = opMatch
+			}
+			i--
+		case opDel:
+			if v.win[j] >= 0 {
+				b.call(i, j, 0, -1)
+			}
+			b.del(j)
+			if X[(i-1)*r.N+j-1]&extDel == 0 {
+				op = opMatch
+			}
+			j--
+		default:
+			if v.win[j] < 0 {
+				b.match(i, j)
+				i, j = i-1, j-1
+				continue
+			}
+			allele := r.allele(a.profile, v, i, j)
+			l := len(allele)
+			if allele[0] == '.' {
+				l = 0
+			}
+			b.site(i, j, l, r.alleleIndex(a.profile, v, i, j))
+			i, j = i-l, j-1
+		}
+	}
+	return i, j
+}
+
+// newAlignment returns the alignment of columns in reference order.
+func newAlignment(cols []alnCol) Alignment {
+	al := Alignment{Pos: -1}
+	var cigar, md []byte
+	var run, matches int
+	var in_del bool
+	for k, c := range cols {
+		if (c.op == opMatch || c.op == opDel) && al.Pos < 0 {
+			al.Pos = c.pos
+		}
+		run++
+		if k == len(cols)-1 || cols[k+1].op != c.op {
+			cigar = append(strconv.AppendInt(cigar, int64(run), 10), c.op)
+			run = 0
+		}
+		switch c.op {
+		case opMatch:
+			in_del = false
+			if c.read == c.ref {
+				matches++
+				continue
+			}
+			md = append(strconv.AppendInt(md, int64(matches), 10), c.ref)
+			matches = 0
+			al.NM++
+		case opDel:
+			if !in_del {
+				md = append(strconv.AppendInt(md, int64(matches), 10), '^')
+				matches, in_del = 0, true
+			}
+			md = append(md, c.ref)
+			al.NM++
+		case opIns:
+			al.NM++
+		}
+	}
+	al.MD = string(strconv.AppendInt(md, int64(matches), 10))
+	al.Cigar = string(cigar)
+	if len(cigar) == 0 {
+		al.Cigar = "*"
+	}
+	return al
+}
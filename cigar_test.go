@@ -0,0 +1,181 @@
+//----------------------------------------------------------------------------------------
+// Test for alignments to the linear reference
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestAlignment(t *testing.T) {
+	defer __(o_())
+
+	p := newProfileSNP(map[int]SNP{3: {profile: []string{"A", "C"}, ref: "A"}, 7: {profile: []string{".", "A", "AT"}, ref: "A"}})
+	genome := []byte("ACC*CGT*CGTA") // reference ACCACGTACGTA
+	var test_cases = []struct {
+		ins, del int
+		read     string
+		al       Alignment
+	}{
+		{0, 0, "ACCACGTACGTA", Alignment{0, "12M", "12", 0}},
+		{0, 0, "ACCCCGTACGTA", Alignment{0, "12M", "3A8", 1}},
+		{0, 0, "ACCACGTATCGTA", Alignment{0, "8M1I4M", "12", 1}},
+		{0, 0, "ACCACGTCGTA", Alignment{0, "7M1D4M", "7^A4", 1}},
+		{0, 0, "ACGACGTACGTA", Alignment{0, "12M", "2C9", 1}},
+		{0, 0, "ACCACTGTACGTA", Alignment{-1, "*", "", 0}},
+		{1, 1, "ACCACTGTACGTA", Alignment{0, "5M1I7M", "12", 1}},
+		{1, 1, "ACCCCGTATCGTA", Alignment{0, "8M1I4M", "3A8", 2}},
+		{1, 1, "ACGACGTACGTA", Alignment{0, "12M", "2C9", 1}},
+		{1, 1, "GACCACGTACGTA", Alignment{0, "1I12M", "12", 1}},
+	}
+	for i, tc := range test_cases {
+		a := NewProfileAligner(p, Config{DistThres: INF, Ins: tc.ins, Del: tc.del})
+		read := []byte(tc.read)
+		r := a.Backward(read, genome, 0)
+		if al := a.BackwardAlignment(read, genome, r, 0); al != tc.al {
+			t.Errorf("Fail backward alignment (case %d): got %v, want %v", i, al, tc.al)
+		}
+		r = a.Forward(read, genome, 0)
+		if al := a.ForwardAlignment(read, genome, r, 0); al != tc.al {
+			t.Errorf("Fail forward alignment (case %d): got %v, want %v", i, al, tc.al)
+		}
+	}
+	// Sites whose reference allele is not known are not mismatches.
+	alleles := map[int][][]byte{3: {[]byte("A"), []byte("C")}, 7: {[]byte("."), []byte("A"), []byte("AT")}}
+	a := NewProfileAligner(NewProfile(alleles), Config{DistThres: INF})
+	read := []byte("ACCCCGTACGTA")
+	if al := a.BackwardAlignment(read, genome, a.Backward(read, genome, 0), 0); al != (Alignment{0, "12M", "12", 0}) {
+		t.Errorf("Fail alignment without reference alleles: got %v", al)
+	}
+
+	// Alignments of random reads are consistent with the read and the reference.
+	rnd := rand.New(rand.NewSource(8))
+	ref := make([]byte, 150)
+	for i := range ref {
+		ref[i] = "ACGT"[rnd.Intn(4)]
+	}
+	genome = append([]byte{}, ref...)
+	SNP_arr := map[int]SNP{}
+	for _, pos := range []int{20, 31, 70, 72, 90, 120} {
+		alt := string("ACGT"[rnd.Intn(4)])
+		if pos%2 == 0 {
+			alt = string(ref[pos]) + alt
+		}
+		SNP_arr[pos] = SNP{profile: []string{string(ref[pos]), alt, "."}, ref: string(ref[pos])}
+		genome[pos] = '*'
+	}
+	p = newProfileSNP(SNP_arr)
+	for _, cfg := range []Config{{DistThres: INF}, {DistThres: INF, Ins: 1, Del: 1}, {DistThres: 6, Ins: 2, Del: 2, GapOpen: 1}} {
+		a := NewProfileAligner(p, cfg)
+		for k := 0; k < 100; k++ {
+			start := rnd.Intn(60)
+			var read []byte
+			for j := start; j < start+80; j++ {
+				if snp, ok := SNP_arr[j]; ok {
+					if allele := snp.profile[rnd.Intn(3)]; allele != "." {
+						read = append(read, allele...)
+					}
+				} else if rnd.Intn(30) == 0 {
+					read = append(read, "ACGT"[rnd.Intn(4)])
+				} else if rnd.Intn(40) != 0 || cfg.Ins == 0 {
+					read = append(read, ref[j])
+				}
+			}
+			t_start, t_end := start-5, start+85
+			if t_start < 0 {
+				t_start = 0
+			}
+			r := a.Backward(read, genome[t_start:start+80], t_start)
+			al := a.BackwardAlignment(read, genome[t_start:start+80], r, t_start)
+			if err := checkAlignment(read, ref, al); r.Distance() <= cfg.DistThres && err != nil {
+				t.Errorf("Fail backward alignment (config %v, read %d %s): %v %v", cfg, k, read, al, err)
+			}
+			r = a.Forward(read, genome[start:t_end], start)
+			al = a.ForwardAlignment(read, genome[start:t_end], r, start)
+			if err := checkAlignment(read, ref, al); r.Distance() <= cfg.DistThres && err != nil {
+				t.Errorf("Fail forward alignment (config %v, read %d %s): %v %v", cfg, k, read, al, err)
+			}
+		}
+	}
+}
+
+// checkAlignment checks that an alignment gives the read from the reference, and that its MD and
+// NM tags agree with them.
+func checkAlignment(read, ref []byte, al Alignment) error {
+	var md_ref []byte // reference bases of M and D operations, from the read and the MD tag
+	var mismatches, nm int
+	i, j := 0, al.Pos
+	for c := al.Cigar; len(c) > 0; {
+		k := 0
+		for c[k] >= '0' && c[k] <= '9' {
+			k++
+		}
+		l, _ := strconv.Atoi(c[:k])
+		switch c[k] {
+		case 'M':
+			for x := 0; x < l; x++ {
+				if read[i+x] != ref[j+x] {
+					mismatches++
+				}
+				md_ref = append(md_ref, read[i+x])
+			}
+			i, j = i+l, j+l
+		case 'I':
+			i += l
+			nm += l
+		case 'S':
+			if k+1 < len(c) && i > 0 {
+				return fmt.Errorf("soft clip inside the alignment")
+			}
+			i += l
+		case 'D':
+			md_ref = append(md_ref, ref[j:j+l]...)
+			j += l
+			nm += l
+		}
+		c = c[k+1:]
+	}
+	if i != len(read) {
+		return fmt.Errorf("alignment of %d read bases", i)
+	}
+	if nm+mismatches != al.NM {
+		return fmt.Errorf("NM %d, want %d", al.NM, nm+mismatches)
+	}
+	// Apply the MD tag to the read bases, skipping the deleted bases taken from the reference.
+	x := 0
+	for md := al.MD; len(md) > 0; {
+		k := 0
+		for k < len(md) && md[k] >= '0' && md[k] <= '9' {
+			k++
+		}
+		n, _ := strconv.Atoi(md[:k])
+		x += n
+		md = md[k:]
+		if len(md) == 0 {
+			break
+		}
+		if md[0] == '^' {
+			md = md[1:]
+			for len(md) > 0 && (md[0] < '0' || md[0] > '9') {
+				x++
+				md = md[1:]
+			}
+		} else {
+			if x >= len(md_ref) {
+				return fmt.Errorf("MD %s is too long", al.MD)
+			}
+			md_ref[x] = md[0]
+			x++
+			md = md[1:]
+		}
+	}
+	if x != len(md_ref) || string(md_ref) != string(ref[al.Pos:j]) {
+		return fmt.Errorf("MD %s gives reference %s, want %s", al.MD, md_ref, ref[al.Pos:j])
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: clipped alignment module.
+// Semi-global and local alignments, which soft-clip read bases that do not come from the genome
+// part (adapters, the other side of a junction) for a cost per clipped end and per clipped base.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+// AlignMode selects the ends of a read which alignments may soft-clip, see Config.Mode.
+type AlignMode int
+
+const (
+	ModeGlobal     AlignMode = iota // the whole read is aligned
+	ModeSemiGlobal                  // the read may be clipped at the free end of the alignment
+	ModeLocal                       // the read may be clipped at both ends, the genome part is free at both ends
+)
+
+// tclip is the value of Workspace.T at the cells of ungapped alignments which start after
+// soft-clipping the read bases of the rows before them.
+const tclip = 0xffff
+
+// clipped returns whether alignments may soft-clip the read.
+func (cfg *Config) clipped() bool {
+	return cfg.Mode != ModeGlobal
+}
+
+// clipCost returns the cost of soft-clipping l read bases at one end of the read.
+func (a *Aligner) clipCost(l int) int {
+	if l == 0 {
+		return 0
+	}
+	if a.cfg.ClipBase <= 0 {
+		return a.cfg.Clip + l
+	}
+	return a.cfg.Clip + l*a.cfg.ClipBase
+}
+
+// leadClip returns whether the i read bases left over at column 0 of a DP matrix are soft-clipped,
+// rather than inserted before the genome part (which ungapped alignments cannot do).
+func (a *Aligner) leadClip(i int) bool {
+	if !a.cfg.clipped() {
+		return false
+	}
+	return a.cfg.Ins <= 0 || a.clipCost(i) < a.cfg.GapOpen+i*a.cfg.Ins
+}
+
+// clipStart returns whether an ungapped alignment through cell (i, j) starts there after
+// soft-clipping rows 1..i.
+func (a *Aligner) clipStart(r *Result, i, j int) bool {
+	if j == 0 {
+		return a.leadClip(i)
+	}
+	return a.cfg.clipped() && r.ws != nil && r.ws.T[(i-1)*r.N+j-1] == tclip
+}
+
+// clipEnd returns the cell (i, j) of a DP matrix of m+1 rows of n+1 distances where a local
+// alignment ends, and its distance: the cell with the smallest distance plus the cost of clipping
+// the read bases of rows i+1..m. The genome columns after j are free. Ties go to the cells which
+// clip and skip the fewest bases.
+func (a *Aligner) clipEnd(D []int, m, n int) (int, int, int) {
+	end_i, end_j, best := m, n, D[m*(n+1)+n]
+	for i := m; i >= 1; i-- {
+		clip := a.clipCost(m - i)
+		for j := n; j >= 0; j-- {
+			if d := D[i*(n+1)+j] + clip; d < best {
+				end_i, end_j, best = i, j, d
+			}
+		}
+	}
+	return end_i, end_j, best
+}
+
+// end returns the cell of the DP matrices where the alignment ends: (M, N) unless read bases or
+// genome bases are left out at the anchored end of a local alignment.
+func (r *Result) end() (int, int) {
+	return r.M - r.AnchorClip, r.N - r.skip
+}
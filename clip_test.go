@@ -0,0 +1,119 @@
+//----------------------------------------------------------------------------------------
+// Test for soft-clipped alignments
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestAlignerClip(t *testing.T) {
+	defer __(o_())
+
+	p := newProfileSNP(map[int]SNP{3: {profile: []string{"A", "C"}, ref: "A"}, 7: {profile: []string{".", "A", "AT"}, ref: "A"}})
+	genome := []byte("ACC*CGT*CGTA") // reference ACCACGTACGTA
+	var test_cases = []struct {
+		mode           AlignMode
+		ins, del       int
+		read           string
+		fwd            bool
+		d, free, anchr int
+		al             Alignment
+	}{
+		{ModeGlobal, 0, 0, "GGGGGCACGTACGTA", false, INF, 0, 0, Alignment{-1, "*", "", 0}},
+		{ModeSemiGlobal, 0, 0, "GGGGGCACGTACGTA", false, 7, 5, 0, Alignment{2, "5S10M", "10", 0}},
+		{ModeSemiGlobal, 2, 2, "GGGGGCACGTACGTA", false, 7, 5, 0, Alignment{2, "5S10M", "10", 0}},
+		{ModeSemiGlobal, 0, 0, "ACCCCGTATCGGGGGG", true, 7, 5, 0, Alignment{0, "8M1I2M5S", "3A6", 2}},
+		{ModeSemiGlobal, 0, 0, "GACCACGTACGTA", false, 3, 1, 0, Alignment{0, "1S12M", "12", 0}},
+		{ModeSemiGlobal, 2, 2, "GACCACGTACGTA", false, 2, 0, 0, Alignment{0, "1I12M", "12", 1}},
+		{ModeSemiGlobal, 0, 0, "ACCACGTACGTT", false, 4, 0, 0, Alignment{0, "12M", "11A0", 1}},
+		{ModeLocal, 0, 0, "GGGGGCACGTACGTATTTT", false, 13, 5, 4, Alignment{2, "5S10M4S", "10", 0}},
+		{ModeLocal, 0, 0, "GGGCACGTAC", false, 5, 3, 0, Alignment{2, "3S7M", "7", 0}},
+		{ModeLocal, 2, 2, "GGGCACGTAC", true, 5, 0, 3, Alignment{2, "3S7M", "7", 0}},
+		{ModeLocal, 2, 2, "GGGCACTGTACTTT", false, 12, 3, 3, Alignment{2, "3S3M1I4M3S", "7", 1}},
+	}
+	for i, tc := range test_cases {
+		a := NewProfileAligner(p, Config{DistThres: INF, Ins: tc.ins, Del: tc.del, Scorer: TsTvScorer{4, 4}, Mode: tc.mode, Clip: 2, ClipBase: 1})
+		read := []byte(tc.read)
+		var r Result
+		var al Alignment
+		if tc.fwd {
+			r = a.Forward(read, genome, 0)
+			al = a.ForwardAlignment(read, genome, r, 0)
+		} else {
+			r = a.Backward(read, genome, 0)
+			al = a.BackwardAlignment(read, genome, r, 0)
+		}
+		if r.Distance() != tc.d || r.FreeClip != tc.free || r.AnchorClip != tc.anchr || al != tc.al {
+			t.Errorf("Fail clipped alignment (case %d): got %d %d %d %v, want %d %d %d %v", i, r.Distance(), r.FreeClip, r.AnchorClip, al, tc.d, tc.free, tc.anchr, tc.al)
+		}
+	}
+
+	// Reads with adapters at either end: clipping never costs more than aligning the whole read,
+	// local alignments never cost more than semi-global ones, and alignments are consistent.
+	rnd := rand.New(rand.NewSource(11))
+	ref := make([]byte, 150)
+	for i := range ref {
+		ref[i] = "ACGT"[rnd.Intn(4)]
+	}
+	genome = append([]byte{}, ref...)
+	SNP_arr := map[int]SNP{}
+	for _, pos := range []int{20, 31, 70, 72, 90, 120} {
+		alt := string("ACGT"[rnd.Intn(4)])
+		if pos%2 == 0 {
+			alt = string(ref[pos]) + alt
+		}
+		SNP_arr[pos] = SNP{profile: []string{string(ref[pos]), alt, "."}, ref: string(ref[pos])}
+		genome[pos] = '*'
+	}
+	p = newProfileSNP(SNP_arr)
+	adapter := func() []byte {
+		b := make([]byte, rnd.Intn(12))
+		for i := range b {
+			b[i] = "ACGT"[rnd.Intn(4)]
+		}
+		return b
+	}
+	for _, cfg := range []Config{{DistThres: INF}, {DistThres: INF, Ins: 1, Del: 1}, {DistThres: INF, Ins: 2, Del: 2, GapOpen: 1}} {
+		for k := 0; k < 50; k++ {
+			start := 5 + rnd.Intn(60)
+			read := adapter()
+			for j := start; j < start+60; j++ {
+				if snp, ok := SNP_arr[j]; ok {
+					if allele := snp.profile[rnd.Intn(3)]; allele != "." {
+						read = append(read, allele...)
+					}
+				} else if rnd.Intn(30) == 0 {
+					read = append(read, "ACGT"[rnd.Intn(4)])
+				} else {
+					read = append(read, ref[j])
+				}
+			}
+			read = append(read, adapter()...)
+			t_start, t_end := start-5, start+80
+			dist := INF
+			for _, mode := range []AlignMode{ModeGlobal, ModeSemiGlobal, ModeLocal} {
+				c := cfg
+				c.Mode, c.Clip = mode, 4
+				a := NewProfileAligner(p, c)
+				r := a.Backward(read, genome[t_start:t_end], t_start)
+				if r.Distance() > dist {
+					t.Errorf("Fail clipped alignment (config %v, read %d %s): distance %d over %d", c, k, read, r.Distance(), dist)
+				}
+				dist = r.Distance()
+				al := a.BackwardAlignment(read, genome[t_start:t_end], r, t_start)
+				if err := checkAlignment(read, ref, al); r.Distance() < INF && err != nil {
+					t.Errorf("Fail clipped alignment (config %v, read %d %s): %v %v", c, k, read, al, err)
+				}
+				r = a.Forward(read, genome[start:t_end], start)
+				al = a.ForwardAlignment(read, genome[start:t_end], r, start)
+				if err := checkAlignment(read, ref, al); r.Distance() < INF && err != nil {
+					t.Errorf("Fail clipped forward alignment (config %v, read %d %s): %v %v", c, k, read, al, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,334 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: sequence dictionary module.
+// Per-contig lengths and MD5 checksums of the reference genome (as in SAM @SQ M5 / Picard .dict),
+// saved along with multigenomes and verified when they are loaded.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//-------------------------------------------------------------------------------------------------
+// Contigs and sequence dictionaries.
+//-------------------------------------------------------------------------------------------------
+
+// Contig describes one sequence of the reference genome.
+// Contigs are concatenated in FASTA order to form the (multi)genome.
+type Contig struct {
+	Name   string // first word of the FASTA header
+	Offset int    // position of the first base in the concatenated genome
+	Len    int    // number of bases
+	MD5    string // hex MD5 of the upper-cased bases, as in SAM @SQ M5
+
+	// Circular contigs (mitochondria, plasmids, as in SAM @SQ TP:circular) continue at their first
+	// base after their last one, so that reads can align across their origin.
+	Circular bool
+}
+
+// SeqDict is the sequence dictionary of a multigenome.
+// Besides the reference contigs, it records checksums of the starred genome and of the SNP profile
+// it was built with, so that mismatched files are detected on load.
+type SeqDict struct {
+	Contigs    []Contig
+	GenomeMD5  string         // MD5 of the starred multigenome
+	ProfileMD5 string         // MD5 of the SNP profile, see profileMD5
+	Refs       map[int]int    // index of the reference allele of sites where it is known, by position
+	ShardMD5   map[int]string // MD5 of the SNP profile of each contig's shard, by contig, see SaveSharded
+	Meta       map[string]string
+}
+
+// NewSeqDict creates a sequence dictionary for a multigenome built from the given contigs.
+func NewSeqDict(contigs []Contig, multi []byte, SNP_arr map[int]SNP) *SeqDict {
+	profile := make(map[int][][]byte, len(SNP_arr))
+	refs := make(map[int]int)
+	for pos, snp := range SNP_arr {
+		b := make([][]byte, len(snp.profile))
+		for i, v := range snp.profile {
+			b[i] = []byte(v)
+		}
+		profile[pos] = b
+		if i := snp.refIndex(); i >= 0 {
+			refs[pos] = i
+		}
+	}
+	d := &SeqDict{Contigs: make([]Contig, len(contigs)), Refs: refs}
+	copy(d.Contigs, contigs)
+	d.GenomeMD5 = md5Hex(multi)
+	d.ProfileMD5 = profileMD5(profile)
+	return d
+}
+
+// Len returns the total length of the contigs in the dictionary.
+func (d *SeqDict) Len() int {
+	l := 0
+	for _, c := range d.Contigs {
+		l += c.Len
+	}
+	return l
+}
+
+// Verify checks that a starred multigenome and a SNP profile are the ones the dictionary was saved with.
+func (d *SeqDict) Verify(multi []byte, profile map[int][][]byte) error {
+	if d.Len() != len(multi) {
+		return fmt.Errorf("multigenome length %d does not match dictionary length %d", len(multi), d.Len())
+	}
+	if d.GenomeMD5 != "" && d.GenomeMD5 != md5Hex(multi) {
+		return fmt.Errorf("multigenome MD5 %s does not match dictionary MD5 %s", md5Hex(multi), d.GenomeMD5)
+	}
+	if d.ProfileMD5 != "" && d.ProfileMD5 != profileMD5(profile) {
+		return fmt.Errorf("SNP profile MD5 %s does not match dictionary MD5 %s", profileMD5(profile), d.ProfileMD5)
+	}
+	for pos := range profile {
+		if pos < 0 || pos >= len(multi) {
+			return fmt.Errorf("SNP position %d is outside the multigenome", pos)
+		}
+	}
+	return nil
+}
+
+// Match checks that the dictionary describes exactly the given reference contigs.
+func (d *SeqDict) Match(contigs []Contig) error {
+	if len(d.Contigs) != len(contigs) {
+		return fmt.Errorf("reference has %d contigs, dictionary has %d", len(contigs), len(d.Contigs))
+	}
+	for i, c := range contigs {
+		e := d.Contigs[i]
+		if e.Name != c.Name {
+			return fmt.Errorf("contig %d is %q in reference, %q in dictionary", i, c.Name, e.Name)
+		}
+		if e.Len != c.Len {
+			return fmt.Errorf("contig %s has length %d in reference, %d in dictionary", c.Name, c.Len, e.Len)
+		}
+		if e.MD5 != "" && e.MD5 != c.MD5 {
+			return fmt.Errorf("contig %s has MD5 %s in reference, %s in dictionary", c.Name, c.MD5, e.MD5)
+		}
+	}
+	return nil
+}
+
+// VerifyFasta checks that the dictionary was built from the given FASTA file.
+func (d *SeqDict) VerifyFasta(fasta_file string) error {
+	_, contigs, err := readFasta(fasta_file)
+	if err != nil {
+		return err
+	}
+	return d.Match(contigs)
+}
+
+//-------------------------------------------------------------------------------------------------
+// Loading and saving sequence dictionaries.
+// The file format is a Picard .dict file: an @HD line, one @SQ line per contig, and @CO lines
+// carrying the multigenome (GM) and SNP profile (PM) checksums and metadata (MT:key=value).
+//-------------------------------------------------------------------------------------------------
+
+// SaveSeqDict saves a sequence dictionary to a file. The file is replaced atomically.
+func SaveSeqDict(file_name string, d *SeqDict) error {
+	return writeFileAtomic(file_name, func(w *bufio.Writer) error {
+		return WriteSeqDict(w, d)
+	})
+}
+
+// WriteSeqDict writes a sequence dictionary in the format of SaveSeqDict.
+func WriteSeqDict(out io.Writer, d *SeqDict) error {
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "@HD\tVN:1.6\n")
+	for _, c := range d.Contigs {
+		fmt.Fprintf(w, "@SQ\tSN:%s\tLN:%d", c.Name, c.Len)
+		if c.MD5 != "" {
+			fmt.Fprintf(w, "\tM5:%s", c.MD5)
+		}
+		if c.Circular {
+			fmt.Fprintf(w, "\tTP:circular")
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	if d.GenomeMD5 != "" {
+		fmt.Fprintf(w, "@CO\tGM:%s\n", d.GenomeMD5)
+	}
+	if d.ProfileMD5 != "" {
+		fmt.Fprintf(w, "@CO\tPM:%s\n", d.ProfileMD5)
+	}
+	pos := make([]int, 0, len(d.Refs))
+	for k := range d.Refs {
+		pos = append(pos, k)
+	}
+	sort.Ints(pos)
+	for _, k := range pos {
+		fmt.Fprintf(w, "@CO\tRF:%d:%d\n", k, d.Refs[k])
+	}
+	for k := range d.Contigs {
+		if sum, ok := d.ShardMD5[k]; ok {
+			fmt.Fprintf(w, "@CO\tSH:%d=%s\n", k, sum)
+		}
+	}
+	keys := make([]string, 0, len(d.Meta))
+	for k := range d.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "@CO\tMT:%s=%s\n", k, d.Meta[k])
+	}
+	return w.Flush()
+}
+
+// LoadSeqDict loads a sequence dictionary from a file.
+func LoadSeqDict(file_name string) (*SeqDict, error) {
+	f, err := os.Open(file_name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d, err := ReadSeqDict(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file_name, err)
+	}
+	return d, nil
+}
+
+// ReadSeqDict reads a sequence dictionary written by WriteSeqDict.
+func ReadSeqDict(r io.Reader) (*SeqDict, error) {
+	var err error
+	d := &SeqDict{}
+	offset := 0
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		split := strings.Split(sc.Text(), "\t")
+		switch split[0] {
+		case "@SQ":
+			c := Contig{Offset: offset, Len: -1}
+			for _, field := range split[1:] {
+				switch {
+				case strings.HasPrefix(field, "SN:"):
+					c.Name = field[3:]
+				case strings.HasPrefix(field, "LN:"):
+					c.Len, err = strconv.Atoi(field[3:])
+					if err != nil {
+						return nil, fmt.Errorf("bad contig length %q", field)
+					}
+				case strings.HasPrefix(field, "M5:"):
+					c.MD5 = field[3:]
+				case field == "TP:circular":
+					c.Circular = true
+				}
+			}
+			if c.Name == "" || c.Len < 0 {
+				return nil, fmt.Errorf("@SQ line without SN or LN")
+			}
+			d.Contigs = append(d.Contigs, c)
+			offset += c.Len
+		case "@CO":
+			for _, field := range split[1:] {
+				switch {
+				case strings.HasPrefix(field, "GM:"):
+					d.GenomeMD5 = field[3:]
+				case strings.HasPrefix(field, "PM:"):
+					d.ProfileMD5 = field[3:]
+				case strings.HasPrefix(field, "RF:"):
+					kv := strings.SplitN(field[3:], ":", 2)
+					if len(kv) != 2 {
+						return nil, fmt.Errorf("bad reference allele %q", field)
+					}
+					pos, e1 := strconv.Atoi(kv[0])
+					ref, e2 := strconv.Atoi(kv[1])
+					if e1 != nil || e2 != nil {
+						return nil, fmt.Errorf("bad reference allele %q", field)
+					}
+					if d.Refs == nil {
+						d.Refs = make(map[int]int)
+					}
+					d.Refs[pos] = ref
+				case strings.HasPrefix(field, "SH:"):
+					kv := strings.SplitN(field[3:], "=", 2)
+					k, e := strconv.Atoi(kv[0])
+					if len(kv) != 2 || e != nil {
+						return nil, fmt.Errorf("bad shard checksum %q", field)
+					}
+					if d.ShardMD5 == nil {
+						d.ShardMD5 = make(map[int]string)
+					}
+					d.ShardMD5[k] = kv[1]
+				case strings.HasPrefix(field, "MT:"):
+					kv := strings.SplitN(field[3:], "=", 2)
+					if len(kv) == 2 {
+						if d.Meta == nil {
+							d.Meta = make(map[string]string)
+						}
+						d.Meta[kv[0]] = kv[1]
+					}
+				}
+			}
+		}
+	}
+	if err = sc.Err(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// LoadMultiChecked loads a multigenome and its SNP profile, and verifies them against the
+// sequence dictionary saved with them.
+func LoadMultiChecked(genome_file, snp_file, dict_file string) ([]byte, map[int][][]byte, map[int]int, error) {
+	d, err := LoadSeqDict(dict_file)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	multi, err := readMulti(genome_file)
+	if err != nil && err != errNoFooter {
+		return nil, nil, nil, err
+	}
+	profile, same_len, err := readSNPLocation(snp_file)
+	if err != nil && err != errNoFooter {
+		return nil, nil, nil, err
+	}
+	if err = d.Verify(multi, profile); err != nil {
+		return nil, nil, nil, fmt.Errorf("%s, %s: %v", genome_file, snp_file, err)
+	}
+	return multi, profile, same_len, nil
+}
+
+//-------------------------------------------------------------------------------------------------
+// Checksums.
+//-------------------------------------------------------------------------------------------------
+
+func md5Hex(b []byte) string {
+	sum := md5.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// profileMD5 computes a checksum of a SNP profile which does not depend on the order of positions
+// or of alleles.
+func profileMD5(profile map[int][][]byte) string {
+	pos := make([]int, 0, len(profile))
+	for k := range profile {
+		pos = append(pos, k)
+	}
+	sort.Ints(pos)
+	h := md5.New()
+	for _, k := range pos {
+		alleles := make([]string, len(profile[k]))
+		for i, v := range profile[k] {
+			alleles[i] = string(v)
+		}
+		sort.Strings(alleles)
+		fmt.Fprintf(h, "%d\t%s\n", k, strings.Join(alleles, "\t"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// contigMD5 computes the SAM M5 checksum of a contig: MD5 of its upper-cased bases.
+func contigMD5(seq []byte) string {
+	return md5Hex(bytes.ToUpper(seq))
+}
@@ -0,0 +1,84 @@
+//----------------------------------------------------------------------------------------
+// Test for sequence dictionaries
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestFastaReadDict(t *testing.T) {
+	defer __(o_())
+
+	seq, contigs, err := readFasta("test_data/toy.fasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var true_contigs = []Contig{
+		{"chrA", 0, 24, "5c4ba5dea02135b655d6aa4f1800d2d1", false},
+		{"chrB", 24, 12, "33b19ba974782f0917ab1bcc6a7633b4", false},
+	}
+	if len(seq) != 36 || len(contigs) != len(true_contigs) {
+		t.Fatalf("Fail reading FASTA (length, contigs): %d %v", len(seq), contigs)
+	}
+	for i, c := range contigs {
+		if c != true_contigs[i] {
+			t.Errorf("Fail contig %d: got %v, want %v", i, c, true_contigs[i])
+		}
+	}
+	fmt.Println(contigs)
+}
+
+func TestSeqDictSaveLoadVerify(t *testing.T) {
+	defer __(o_())
+
+	dir := t.TempDir()
+	genome_file := filepath.Join(dir, "genomestar.txt")
+	snp_file := filepath.Join(dir, "SNPLocation.txt")
+	dict_file := filepath.Join(dir, "genomestar.dict")
+
+	seq, contigs, err := readFasta("test_data/toy.fasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SNP_array := map[int]SNP{2: {profile: []string{"A", "G"}}, 27: {profile: []string{"A", "G"}}}
+	genome := buildMultigenome2(SNP_array, seq)
+	SaveMulti(genome_file, genome)
+	SaveSNPLocation(snp_file, SNP_array)
+	if err = SaveSeqDict(dict_file, NewSeqDict(contigs, genome, SNP_array)); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := LoadSeqDict(dict_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Match(contigs); err != nil {
+		t.Errorf("Fail matching reference: %v", err)
+	}
+	if err = d.VerifyFasta("test_data/toy.fasta"); err != nil {
+		t.Errorf("Fail verifying FASTA: %v", err)
+	}
+	if _, _, _, err = LoadMultiChecked(genome_file, snp_file, dict_file); err != nil {
+		t.Errorf("Fail loading checked multigenome: %v", err)
+	}
+
+	// Wrong SNP profile and wrong reference must be rejected.
+	SaveSNPLocation(snp_file, map[int]SNP{2: {profile: []string{"A", "C"}}, 27: {profile: []string{"A", "G"}}})
+	if _, _, _, err = LoadMultiChecked(genome_file, snp_file, dict_file); err == nil {
+		t.Errorf("Fail detecting mismatched SNP profile")
+	} else {
+		fmt.Println(err)
+	}
+	other := append([]Contig{}, contigs...)
+	other[1].MD5 = contigMD5([]byte("TTGACCATGACC"))
+	if err = d.Match(other); err == nil {
+		t.Errorf("Fail detecting mismatched reference")
+	} else {
+		fmt.Println(err)
+	}
+}
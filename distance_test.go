@@ -78,7 +78,7 @@ func TestBackwarddistance2MultiAlignment(t *testing.T) {
 		read, genome := []byte(test_cases[i].read), []byte(test_cases[i].genome)
 		d, D, m, n, S, T, _ := BackwardDistanceMulti(read, genome, 0)
 		if d + D != test_cases[i].d {
-			t.Errorf("Fail alignment (case, read, genome, calculated distance2, true distance2, d, m, n):",
+			t.Error("Fail alignment (case, read, genome, calculated distance2, true distance2, d, m, n):",
 			 i, string(read), string(genome), d + D, test_cases[i].d, m, n)
 		} else if d + D >= INF {
 			fmt.Println("Successful alignment but with infinity distance2 (distance2, read, genome, d, m, n, case):",
@@ -145,7 +145,7 @@ func TestForwarddistance2MultiAlignment(t *testing.T) {
 		read, genome := []byte(test_cases[i].read), []byte(test_cases[i].genome)
 		d, D, m, n, S, T, _ := ForwardDistanceMulti(read, genome, 0)
 		if d + D != test_cases[i].d {
-			t.Errorf("Fail alignment (read, genome, calculated distance2, true distance2, m, n, case):",
+			t.Error("Fail alignment (read, genome, calculated distance2, true distance2, m, n, case):",
 			 string(read), string(genome), d + D, test_cases[i].d, m, n, i)
 		} else if d + D >= INF {
 			fmt.Println("Successful alignment but with infinity distance2 (distance2, read, genome, d, m, n, case):",
@@ -173,7 +173,7 @@ func TestBackwarddistance2MultiAlignment2(t *testing.T) {
 		read, genome := []byte(test_cases[i].read), []byte(test_cases[i].genome)
 		d, D, m, n, S, T, _ := BackwardDistanceMulti(read, genome, 26042383)
 		if d + D != test_cases[i].d {
-			t.Errorf("Fail alignment (read, genome, calculated distance2, true distance2, m, n, case):",
+			t.Error("Fail alignment (read, genome, calculated distance2, true distance2, m, n, case):",
 			 string(read), string(genome), d + D, test_cases[i].d, m, n, i)
 		} else {
 			fmt.Println("Successful alignment (distance2, read, genome, profile, m, n, case):",
@@ -202,7 +202,7 @@ func TestForwarddistance2MultiAlignment2(t *testing.T) {
 		read, genome := []byte(test_cases[i].read), []byte(test_cases[i].genome)
 		d, D, m, n, S, T, _ := ForwardDistanceMulti(read, genome, 26042372)
 		if d + D != test_cases[i].d {
-			t.Errorf("Fail alignment (read, genome, calculated distance2, true distance2, m, n, case):",
+			t.Error("Fail alignment (read, genome, calculated distance2, true distance2, m, n, case):",
 			 string(read), string(genome), d + D, test_cases[i].d, m, n, i)
 		} else {
 			fmt.Println("Successful alignment (distance2, read, genome, profile, m, n, case):",
@@ -215,3 +215,25 @@ func TestForwarddistance2MultiAlignment2(t *testing.T) {
 		}
 	}
 }
+
+// Test for calling Init while other goroutines use the package-level distance functions
+func TestInitConcurrent(t *testing.T) {
+	defer __(o_())
+
+	profile := type_snpprofile{2: {{'A'}, {'C'}}}
+	read, genome := []byte("ACA"), []byte("AC*")
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			Init(DIST_THRES, profile, type_samelensnp{2: 1}, 100)
+		}
+		done <- true
+	}()
+	for i := 0; i < 100; i++ {
+		d, D, _, _, _, _, _ := BackwardDistanceMulti(read, genome, 0)
+		if d+D > 1 {
+			t.Error("Fail alignment during Init:", d+D)
+		}
+	}
+	<-done
+}
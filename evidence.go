@@ -0,0 +1,45 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: variant evidence module.
+// The alleles chosen by an alignment at the variant sites, with their IDs and the read bases
+// aligned to them, so that callers do not have to match read bytes against the profile again.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+// SiteCall is the evidence of an alignment at a variant site: the read bases s[ReadPos:ReadPos+Len]
+// are aligned to the allele of index Allele.
+type SiteCall struct {
+	Pos     int    // genome position of the site
+	Allele  int    // index of the chosen allele (see Profile.Alleles), -1 if a gap deletes the site
+	ID      string // VCF ID of the chosen allele, "" if unknown (see Profile.AlleleIDs)
+	ReadPos int    // offset in the read of the bases aligned to the site
+	Len     int    // number of read bases aligned to the site, 0 for a deletion
+	DP      bool   // resolved by dynamic programming, rather than by the same-length path from the anchored end
+}
+
+// BackwardEvidence returns the evidence of the alignment of s and t at their variant sites, in
+// increasing position order, from the result of Backward. It returns nil if the read is not aligned.
+func (a *Aligner) BackwardEvidence(s, t []byte, r Result, pos int) []SiteCall {
+	return a.evidence(dpView{s: s, t: t, pos: pos}, r)
+}
+
+// ForwardEvidence returns the evidence of the alignment of s and t at their variant sites, from the
+// result of Forward, see BackwardEvidence.
+func (a *Aligner) ForwardEvidence(s, t []byte, r Result, pos int) []SiteCall {
+	return a.evidence(dpView{s: s, t: t, pos: pos, fwd: true}, r)
+}
+
+func (a *Aligner) evidence(v dpView, r Result) []SiteCall {
+	b := &alnBuilder{a: a, evidence: true}
+	if !a.follow(b, v, r) {
+		return nil
+	}
+	// Sites are followed from the anchored end, which is the end of the genome part in backward direction.
+	if !v.fwd {
+		for x, y := 0, len(b.calls)-1; x < y; x, y = x+1, y-1 {
+			b.calls[x], b.calls[y] = b.calls[y], b.calls[x]
+		}
+	}
+	return b.calls
+}
@@ -0,0 +1,100 @@
+//----------------------------------------------------------------------------------------
+// Test for variant evidence
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestAlignerEvidence(t *testing.T) {
+	defer __(o_())
+
+	mg, err := Build("test_data/toy.fasta", "test_data/toy.vcf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids, ok := mg.Profile().AlleleIDs(12); !ok || fmt.Sprint(ids) != "[rs2  rs2]" {
+		t.Errorf("Fail reading allele IDs: got %q %v", ids, ok)
+	}
+	// The ID column lists the IDs of the whole record, so every alternate allele gets all of them.
+	vcf_file := filepath.Join(t.TempDir(), "ids.vcf")
+	ioutil.WriteFile(vcf_file, []byte("chr\t4\trs7;rs8\tA\tC\nchr\t4\trs9\tA\tG\nchr\t8\trs10\tA\tC,T\n"), 0644)
+	if SNP_arr, err := readVCF(vcf_file, nil); err != nil || fmt.Sprint(SNP_arr[3].ids, SNP_arr[7].ids) != "map[C:rs7;rs8 G:rs9] map[C:rs10 T:rs10]" {
+		t.Errorf("Fail reading allele IDs of VCF records: %v %v %v", SNP_arr[3].ids, SNP_arr[7].ids, err)
+	}
+	genome := mg.Seq()[:24] // AC*TACGTACGT*CGTTTA*CCGG
+	a := NewAligner(mg, DefaultConfig())
+	var test_cases = []struct {
+		read     string
+		bwd, fwd string
+	}{
+		{"ACATACGTACGTATCGTTTACCCGG",
+			"[{2 0 rs1 2 1 true} {12 2 rs2 12 2 true} {19 1 rs3 20 1 false}]",
+			"[{2 0 rs1 2 1 false} {12 2 rs2 12 2 true} {19 1 rs3 20 1 true}]"},
+		{"ACGTACGTACGTCGTTTAACCGG",
+			"[{2 1  2 1 true} {12 0 rs2 12 0 true} {19 0  18 1 false}]",
+			"[{2 1  2 1 false} {12 0 rs2 12 0 true} {19 0  18 1 true}]"},
+	}
+	for i, tc := range test_cases {
+		read := []byte(tc.read)
+		r := a.Backward(read, genome, 0)
+		if e := a.BackwardEvidence(read, genome, r, 0); fmt.Sprint(e) != tc.bwd {
+			t.Errorf("Fail backward evidence (case %d): got %v, want %s", i, e, tc.bwd)
+		}
+		r = a.Forward(read, genome, 0)
+		if e := a.ForwardEvidence(read, genome, r, 0); fmt.Sprint(e) != tc.fwd {
+			t.Errorf("Fail forward evidence (case %d): got %v, want %s", i, e, tc.fwd)
+		}
+	}
+
+	// A site deleted by a gap has no allele.
+	g := NewProfileAligner(NewProfile(type_snpprofile{4: {{'C'}}}), Config{DistThres: INF, Ins: 1, Del: 1})
+	read, genome := []byte("ACGTACGT"), []byte("ACGT*ACGT")
+	r := g.Backward(read, genome, 0)
+	if e := g.BackwardEvidence(read, genome, r, 0); fmt.Sprint(e) != "[{4 -1  4 0 true}]" {
+		t.Errorf("Fail evidence of a deleted site: got %v", e)
+	}
+
+	// Alleles of alignments with qualities are chosen with the costs of the qualities.
+	q := NewProfileAligner(NewProfile(type_snpprofile{3: {{'C', 'G'}, {'A', 'T'}}}), DefaultConfig())
+	read, genome = []byte("ACGGTACGT"), []byte("ACG*ACGT")
+	qual := []byte("IIII#IIII")
+	if r, err := q.BackwardQual(read, qual, genome, 0); err != nil || fmt.Sprint(q.BackwardEvidence(read, genome, r, 0)) != "[{3 1  3 2 false}]" {
+		t.Errorf("Fail backward evidence with qualities: got %v %v", q.BackwardEvidence(read, genome, r, 0), err)
+	}
+	if r, err := q.ForwardQual(read, qual, genome, 0); err != nil || fmt.Sprint(q.ForwardEvidence(read, genome, r, 0)) != "[{3 1  3 2 false}]" {
+		t.Errorf("Fail forward evidence with qualities: got %v %v", q.ForwardEvidence(read, genome, r, 0), err)
+	}
+
+	// The evidence agrees with the read bases of the traceback.
+	genome, profile, reads := bandData(rand.New(rand.NewSource(12)))
+	p := NewProfile(profile)
+	for _, cfg := range []Config{{DistThres: INF}, {DistThres: INF, Ins: 1, Del: 1}} {
+		a := NewProfileAligner(p, cfg)
+		for k, read := range reads {
+			r := a.Forward(read, genome, 0)
+			if r.Distance() >= INF {
+				continue
+			}
+			calls, e := a.ForwardTraceBack(read, genome, r, 0), a.ForwardEvidence(read, genome, r, 0)
+			for x, c := range e {
+				alleles, _ := p.Alleles(c.Pos)
+				if string(calls[c.Pos]) != string(read[c.ReadPos:c.ReadPos+c.Len]) || (x > 0 && c.Pos <= e[x-1].Pos) ||
+					(c.Allele >= 0 && alleles[c.Allele][0] != '.' && len(alleles[c.Allele]) != c.Len) {
+					t.Errorf("Fail evidence (config %v, read %d): got %v, traceback %v", cfg, k, e, calls)
+					break
+				}
+			}
+			if len(e) != len(calls) {
+				t.Errorf("Fail evidence (config %v, read %d): got %v, traceback %v", cfg, k, e, calls)
+			}
+		}
+	}
+}
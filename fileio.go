@@ -0,0 +1,154 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: file module.
+// Atomic file writes, and footers which let loaders detect truncated text files.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+//-------------------------------------------------------------------------------------------------
+// Atomic writes.
+// A file is written to a temporary file in the same directory, synced and renamed, so that an
+// interrupted write never leaves a partial file under the final name.
+//-------------------------------------------------------------------------------------------------
+
+// writeFileAtomic creates or replaces a file with the data written by write.
+func writeFileAtomic(file_name string, write func(w *bufio.Writer) error) (err error) {
+	dir, base := filepath.Split(file_name)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	w := bufio.NewWriter(tmp)
+	if err = write(w); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(0644); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), file_name); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir flushes a directory entry to disk. Errors are ignored, since some systems cannot sync
+// directories.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+//-------------------------------------------------------------------------------------------------
+// Headers and footers of text files.
+// The first line of a saved starred genome or SNP profile file is the header line below, and the
+// last line is
+//	#END <length> <checksum>
+// with the number of bytes between the two lines and their CRC-32C in hexadecimal, separated by
+// tabs. A file with the header must have a matching footer, so that files cut off anywhere are
+// detected. Files saved before headers were introduced are verified if they have a footer, and
+// files saved before footers were introduced are not verified.
+//-------------------------------------------------------------------------------------------------
+
+const (
+	headerLine = "#MULTIGENOME\t1\n"
+	footerTag  = "#END\t"
+)
+
+// errNoFooter is returned with the contents of a file which has no footer, by the unexported
+// readers; the exported ones read such files without an error.
+var errNoFooter = errors.New("no footer")
+
+// ErrTruncated is returned when loading a file whose footer does not match its contents.
+var ErrTruncated = errors.New("truncated or corrupted file")
+
+// footerWriter writes data and keeps track of its length and checksum for the footer.
+type footerWriter struct {
+	w   *bufio.Writer
+	n   int
+	crc uint32
+}
+
+func (fw *footerWriter) Write(b []byte) (int, error) {
+	fw.n += len(b)
+	fw.crc = crc32.Update(fw.crc, crcTable, b)
+	return fw.w.Write(b)
+}
+
+func (fw *footerWriter) WriteString(s string) (int, error) {
+	return fw.Write([]byte(s))
+}
+
+// header writes the header line, which is not part of the length and checksum of the footer.
+func (fw *footerWriter) header() error {
+	_, err := fw.w.WriteString(headerLine)
+	return err
+}
+
+// footer writes the footer line.
+func (fw *footerWriter) footer() error {
+	_, err := fmt.Fprintf(fw.w, "%s%d\t%08x\n", footerTag, fw.n, fw.crc)
+	return err
+}
+
+// splitFooter returns the contents of a file without its header and footer lines, after checking
+// them. If the file has neither, it returns the whole file and errNoFooter.
+func splitFooter(data []byte) ([]byte, error) {
+	header := bytes.HasPrefix(data, []byte(headerLine))
+	if header {
+		data = data[len(headerLine):]
+	} else if len(data) > 0 && bytes.HasPrefix([]byte(headerLine), data) {
+		return nil, ErrTruncated // cut off in the header
+	}
+	last := bytes.LastIndexByte(bytes.TrimSuffix(data, []byte("\n")), '\n') + 1
+	if !bytes.HasPrefix(data[last:], []byte(footerTag)) {
+		if header {
+			return nil, ErrTruncated
+		}
+		return data, errNoFooter
+	}
+	body := data[:last]
+	f := bytes.Split(bytes.TrimSuffix(data[last+len(footerTag):], []byte("\n")), []byte("\t"))
+	if len(f) != 2 || !bytes.HasSuffix(data, []byte("\n")) {
+		return nil, ErrTruncated
+	}
+	n, err1 := strconv.Atoi(string(f[0]))
+	crc, err2 := strconv.ParseUint(string(f[1]), 16, 32)
+	if err1 != nil || err2 != nil || n != len(body) || uint32(crc) != crc32.Checksum(body, crcTable) {
+		return nil, ErrTruncated
+	}
+	return body, nil
+}
@@ -0,0 +1,99 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: test of file module.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveFooter(t *testing.T) {
+	defer __(o_())
+
+	dir := t.TempDir()
+	genome_file := filepath.Join(dir, "genomestar.txt")
+	snp_file := filepath.Join(dir, "SNPLocation.txt")
+	SNP_array := map[int]SNP{3: {profile: []string{"A", "C"}}, 7: {profile: []string{".", "A", "AT"}}}
+	genome := buildMultigenome2(SNP_array, []byte("ACGTACGTAC"))
+	if err := SaveMulti(genome_file, genome); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveSNPLocation(snp_file, SNP_array); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveMulti(filepath.Join(dir, "missing", "genomestar.txt"), genome); err == nil {
+		t.Errorf("Fail reporting write error")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 2 {
+		t.Errorf("Fail removing temporary files: %d files", len(files))
+	}
+
+	if multi, err := readMulti(genome_file); err != nil || string(multi) != string(genome) {
+		t.Errorf("Fail loading genome: %q %v", multi, err)
+	}
+	if profile, _, err := readSNPLocation(snp_file); err != nil || len(profile) != 2 {
+		t.Errorf("Fail loading SNP locations: %v %v", profile, err)
+	}
+
+	// Truncated or modified files are detected.
+	data, _ := ioutil.ReadFile(snp_file)
+	for _, bad := range [][]byte{data[:len(data)-4], append([]byte("4"), data[1:]...)} {
+		ioutil.WriteFile(snp_file, bad, 0644)
+		if _, _, err := readSNPLocation(snp_file); !errors.Is(err, ErrTruncated) {
+			t.Errorf("Fail detecting bad SNP locations %q: %v", bad, err)
+		}
+	}
+
+	// Files cut off anywhere, which lose their footer, are detected.
+	SaveSNPLocation(snp_file, SNP_array)
+	data, _ = ioutil.ReadFile(snp_file)
+	SaveMulti(genome_file, genome)
+	gdata, _ := ioutil.ReadFile(genome_file)
+	for _, n := range []int{5, len(headerLine) + 5, len(gdata) - 10} {
+		ioutil.WriteFile(genome_file, gdata[:n], 0644)
+		if _, err := readMulti(genome_file); !errors.Is(err, ErrTruncated) || LoadMulti(genome_file) != nil {
+			t.Errorf("Fail detecting genome cut off after %d bytes: %v", n, err)
+		}
+		if _, err := Import(genome_file, snp_file, ""); !errors.Is(err, ErrTruncated) {
+			t.Errorf("Fail detecting imported genome cut off after %d bytes: %v", n, err)
+		}
+	}
+	ioutil.WriteFile(genome_file, gdata, 0644)
+	for _, n := range []int{len(headerLine) + 3, len(data) - 10} {
+		ioutil.WriteFile(snp_file, data[:n], 0644)
+		if _, _, err := readSNPLocation(snp_file); !errors.Is(err, ErrTruncated) {
+			t.Errorf("Fail detecting SNP locations cut off after %d bytes: %v", n, err)
+		}
+		if _, err := Import(genome_file, snp_file, ""); !errors.Is(err, ErrTruncated) {
+			t.Errorf("Fail detecting imported SNP locations cut off after %d bytes: %v", n, err)
+		}
+	}
+
+	data, _ = ioutil.ReadFile(genome_file)
+	ioutil.WriteFile(genome_file, append([]byte("T"), data[1:]...), 0644)
+	if LoadMulti(genome_file) != nil {
+		t.Errorf("Fail detecting bad genome")
+	}
+
+	// Files without a footer are loaded as before.
+	ioutil.WriteFile(genome_file, genome, 0644)
+	if multi, err := readMulti(genome_file); err != errNoFooter || string(multi) != string(genome) {
+		t.Errorf("Fail loading genome without footer: %q %v", multi, err)
+	}
+	if string(LoadMulti(genome_file)) != string(genome) {
+		t.Errorf("Fail loading genome without footer")
+	}
+	if multi, err := ReadMulti(bytes.NewReader(genome)); err != nil || string(multi) != string(genome) {
+		t.Errorf("Fail reading genome without footer: %q %v", multi, err)
+	}
+	if profile, _, err := ReadSNPLocation(strings.NewReader("3\tA\tC\n7\t.\tA\tAT\n")); err != nil || len(profile) != 2 {
+		t.Errorf("Fail reading SNP locations without footer: %v %v", profile, err)
+	}
+}
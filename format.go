@@ -0,0 +1,463 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: binary format module.
+// Self-describing, versioned binary files holding a multigenome with its contigs, SNP profile and
+// metadata in one file, with a checksum.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+)
+
+//-------------------------------------------------------------------------------------------------
+// File layout (all integers little-endian):
+// 	header:  magic "MGBN", version uint16, reserved uint16
+// 	section: tag [4]byte, reserved uint32, length uint64, payload, zero padding to 8 bytes
+// Sections, in this order:
+// 	"META" metadata: count, then key and value strings (uvarint lengths)
+// 	"CTGS" contigs: count, then name string, offset, length (uvarints) and 16-byte MD5 (or zeros)
+// 	"CIRC" circular contigs: count, then contig indices (uvarints) in increasing order; optional
+// 	"SEQ " starred sequence, one byte per base
+// 	"SITE" variant positions, uint32 array in increasing order
+// 	"AIDX" first allele of each site, uint32 array with one more entry than sites
+// 	"AOFF" offset of each allele in "ABLB", uint32 array with one more entry than alleles
+// 	"ABLB" allele bytes
+// 	"AREF" index of the reference allele of each site, uint16 array (0xffff if unknown)
+// 	"AFRQ" frequency of each allele, float32 array (negative if unknown); optional
+// 	"IOFF" offset of the VCF ID of each allele in "IBLB", uint32 array as "AOFF"; optional
+// 	"IBLB" allele ID bytes; optional, with "IOFF"
+// 	"END " CRC-32C of everything before this section's payload (uint32), reserved uint32,
+// 	       file length (uint64)
+// Readers skip sections they do not know. Fixed-width arrays are 8-byte aligned in the file.
+//-------------------------------------------------------------------------------------------------
+
+const (
+	binMagic   = "MGBN"
+	binVersion = 1
+	noRef      = 0xffff
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrBadFormat is returned when loading a file which is not a valid binary multigenome.
+var ErrBadFormat = errors.New("not a binary multigenome file")
+
+//-------------------------------------------------------------------------------------------------
+// Saving.
+//-------------------------------------------------------------------------------------------------
+
+// SaveBinary saves a multigenome in binary format. The file is replaced atomically.
+func (mg *Multigenome) SaveBinary(file_name string) error {
+	return writeFileAtomic(file_name, func(w *bufio.Writer) error {
+		return mg.WriteBinary(w)
+	})
+}
+
+// binWriter writes sections and keeps track of the checksum and the file offset.
+type binWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	off int64
+	err error
+}
+
+func (bw *binWriter) write(b []byte) {
+	if bw.err != nil {
+		return
+	}
+	_, bw.err = bw.w.Write(b)
+	bw.crc.Write(b)
+	bw.off += int64(len(b))
+}
+
+func (bw *binWriter) section(tag string, length int) {
+	var h [16]byte
+	copy(h[0:4], tag)
+	binary.LittleEndian.PutUint64(h[8:], uint64(length))
+	bw.write(h[:])
+}
+
+func (bw *binWriter) pad() {
+	var zero [8]byte
+	bw.write(zero[:(8-bw.off%8)%8])
+}
+
+// header writes the file header of a binary format.
+func (bw *binWriter) header(magic string, version uint16) {
+	var header [8]byte
+	copy(header[:], magic)
+	binary.LittleEndian.PutUint16(header[4:], version)
+	bw.write(header[:])
+}
+
+// end writes the "END " section with the checksum and the file length, and flushes the file.
+func (bw *binWriter) end() error {
+	bw.section("END ", 16)
+	var end [16]byte
+	binary.LittleEndian.PutUint32(end[0:], bw.crc.Sum32())
+	binary.LittleEndian.PutUint64(end[8:], uint64(bw.off+16))
+	bw.write(end[:])
+	if bw.err != nil {
+		return bw.err
+	}
+	return bw.w.Flush()
+}
+
+func (bw *binWriter) uint32s(a []uint32) {
+	var b [4]byte
+	for _, v := range a {
+		binary.LittleEndian.PutUint32(b[:], v)
+		bw.write(b[:])
+	}
+}
+
+func (bw *binWriter) uint64s(a []uint64) {
+	var b [8]byte
+	for _, v := range a {
+		binary.LittleEndian.PutUint64(b[:], v)
+		bw.write(b[:])
+	}
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUvarint(b, uint64(len(s))), s...)
+}
+
+// WriteBinary writes a multigenome in the format of SaveBinary.
+func (mg *Multigenome) WriteBinary(out io.Writer) error {
+	if uint64(len(mg.seq)) > math.MaxUint32 {
+		return fmt.Errorf("multigenome of length %d is too long for the binary format", len(mg.seq))
+	}
+	bw := &binWriter{w: bufio.NewWriter(out), crc: crc32.New(crcTable)}
+	bw.header(binMagic, binVersion)
+
+	keys := make([]string, 0, len(mg.meta))
+	for k := range mg.meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	meta := appendUvarint(nil, uint64(len(keys)))
+	for _, k := range keys {
+		meta = appendString(appendString(meta, k), mg.meta[k])
+	}
+	bw.section("META", len(meta))
+	bw.write(meta)
+	bw.pad()
+
+	ctgs := appendUvarint(nil, uint64(len(mg.contigs)))
+	for _, c := range mg.contigs {
+		ctgs = appendString(ctgs, c.Name)
+		ctgs = appendUvarint(ctgs, uint64(c.Offset))
+		ctgs = appendUvarint(ctgs, uint64(c.Len))
+		sum := make([]byte, 16)
+		if c.MD5 != "" {
+			if b, err := hex.DecodeString(c.MD5); err == nil && len(b) == 16 {
+				sum = b
+			}
+		}
+		ctgs = append(ctgs, sum...)
+	}
+	bw.section("CTGS", len(ctgs))
+	bw.write(ctgs)
+	bw.pad()
+	var circ []byte
+	for k, c := range mg.contigs {
+		if c.Circular {
+			circ = appendUvarint(circ, uint64(k))
+		}
+	}
+	if circ != nil {
+		circ = append(appendUvarint(nil, uint64(len(circ))), circ...)
+		bw.section("CIRC", len(circ))
+		bw.write(circ)
+		bw.pad()
+	}
+
+	bw.section("SEQ ", len(mg.seq))
+	bw.write(mg.seq)
+	bw.pad()
+
+	p := mg.profile
+	if uint64(len(p.blob)) > math.MaxUint32 {
+		return fmt.Errorf("SNP profile is too large for the binary format")
+	}
+	bw.section("SITE", 4*len(p.sites))
+	bw.uint32s(p.sites)
+	bw.pad()
+	bw.section("AIDX", 4*len(p.aidx))
+	bw.uint32s(p.aidx)
+	bw.pad()
+	bw.section("AOFF", 4*len(p.aoff))
+	bw.uint32s(p.aoff)
+	bw.pad()
+	bw.section("ABLB", len(p.blob))
+	bw.write(p.blob)
+	bw.pad()
+	bw.section("AREF", len(p.aref))
+	bw.write(p.aref)
+	bw.pad()
+	if p.afrq != nil {
+		bw.section("AFRQ", 4*len(p.afrq))
+		bw.uint32s(p.afrq)
+		bw.pad()
+	}
+	if p.ioff != nil {
+		bw.section("IOFF", 4*len(p.ioff))
+		bw.uint32s(p.ioff)
+		bw.pad()
+		bw.section("IBLB", len(p.iblb))
+		bw.write(p.iblb)
+		bw.pad()
+	}
+	return bw.end()
+}
+
+//-------------------------------------------------------------------------------------------------
+// Loading.
+//-------------------------------------------------------------------------------------------------
+
+// LoadBinary loads a multigenome saved by SaveBinary, and verifies its checksum.
+func LoadBinary(file_name string) (*Multigenome, error) {
+	data, err := ioutil.ReadFile(file_name)
+	if err != nil {
+		return nil, err
+	}
+	mg, err := decodeBinary(data, true)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file_name, err)
+	}
+	return mg, nil
+}
+
+// ReadBinary reads a multigenome written by WriteBinary, and verifies its checksum.
+func ReadBinary(r io.Reader) (*Multigenome, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBinary(data, true)
+}
+
+// binSections splits a binary multigenome into sections, after checking its header and checksum.
+func binSections(data []byte, verify bool) (map[string][]byte, error) {
+	return readSections(data, binMagic, binVersion, verify)
+}
+
+// readSections splits a file of a binary format with the given magic and version into sections.
+func readSections(data []byte, magic string, version uint16, verify bool) (map[string][]byte, error) {
+	if len(data) < 8 || string(data[:4]) != magic {
+		return nil, ErrBadFormat
+	}
+	if v := binary.LittleEndian.Uint16(data[4:]); v > version {
+		return nil, fmt.Errorf("binary multigenome version %d is newer than supported version %d", v, version)
+	}
+	sections := make(map[string][]byte)
+	off := 8
+	for {
+		if off+16 > len(data) {
+			return nil, fmt.Errorf("truncated binary multigenome")
+		}
+		tag := string(data[off : off+4])
+		length := binary.LittleEndian.Uint64(data[off+8:])
+		off += 16
+		if length > uint64(len(data)-off) {
+			return nil, fmt.Errorf("truncated binary multigenome in section %q", tag)
+		}
+		payload := data[off : off+int(length)]
+		if tag == "END " {
+			if length != 16 || binary.LittleEndian.Uint64(payload[8:]) != uint64(len(data)) {
+				return nil, fmt.Errorf("truncated binary multigenome")
+			}
+			if verify && crc32.Checksum(data[:off], crcTable) != binary.LittleEndian.Uint32(payload) {
+				return nil, fmt.Errorf("checksum mismatch in binary multigenome")
+			}
+			return sections, nil
+		}
+		sections[tag] = payload
+		off += int(length)
+		off += (8 - off%8) % 8
+	}
+}
+
+// binReader decodes uvarints and strings from a section.
+type binReader struct {
+	b   []byte
+	err error
+}
+
+func (br *binReader) uvarint() uint64 {
+	if br.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(br.b)
+	if n <= 0 {
+		br.err = fmt.Errorf("bad integer in binary multigenome")
+		return 0
+	}
+	br.b = br.b[n:]
+	return v
+}
+
+func (br *binReader) bytes(n uint64) []byte {
+	if br.err != nil {
+		return nil
+	}
+	if n > uint64(len(br.b)) {
+		br.err = fmt.Errorf("truncated string in binary multigenome")
+		return nil
+	}
+	b := br.b[:n]
+	br.b = br.b[n:]
+	return b
+}
+
+func (br *binReader) string() string {
+	return string(br.bytes(br.uvarint()))
+}
+
+// decodeBinary decodes a binary multigenome. The sequence and the SNP profile arrays are used in place,
+// without copying. The layout and the consistency of the multigenome are always checked; if verify
+// is set, so is the checksum, which reads the whole file.
+func decodeBinary(data []byte, verify bool) (*Multigenome, error) {
+	sec, err := binSections(data, verify)
+	if err != nil {
+		return nil, err
+	}
+	meta := make(map[string]string)
+	br := &binReader{b: sec["META"]}
+	for k := br.uvarint(); k > 0 && br.err == nil; k-- {
+		key := br.string()
+		meta[key] = br.string()
+	}
+	var contigs []Contig
+	br.b = sec["CTGS"]
+	for k := br.uvarint(); k > 0 && br.err == nil; k-- {
+		c := Contig{Name: br.string(), Offset: int(br.uvarint()), Len: int(br.uvarint())}
+		if sum := br.bytes(16); sum != nil && string(sum) != string(make([]byte, 16)) {
+			c.MD5 = hex.EncodeToString(sum)
+		}
+		contigs = append(contigs, c)
+	}
+	if circ, ok := sec["CIRC"]; ok && br.err == nil {
+		br.b = circ
+		for k := br.uvarint(); k > 0 && br.err == nil; k-- {
+			if i := br.uvarint(); i < uint64(len(contigs)) {
+				contigs[i].Circular = true
+			} else if br.err == nil {
+				br.err = fmt.Errorf("circular contig %d outside the contigs of binary multigenome", i)
+			}
+		}
+	}
+	if br.err != nil {
+		return nil, br.err
+	}
+	seq, ok := sec["SEQ "]
+	if !ok {
+		return nil, fmt.Errorf("binary multigenome without sequence")
+	}
+
+	f := &Profile{
+		sites: le32s(sec["SITE"]),
+		aidx:  le32s(sec["AIDX"]),
+		aoff:  le32s(sec["AOFF"]),
+		blob:  sec["ABLB"],
+		aref:  sec["AREF"],
+	}
+	if len(f.aidx) != len(f.sites)+1 || len(f.aoff) == 0 || int(f.aidx[len(f.sites)]) != len(f.aoff)-1 ||
+		int(f.aoff[len(f.aoff)-1]) != len(f.blob) || len(f.aref) != 2*len(f.sites) {
+		return nil, fmt.Errorf("inconsistent SNP profile in binary multigenome")
+	}
+	if afrq, ok := sec["AFRQ"]; ok {
+		if len(afrq) != 4*(len(f.aoff)-1) {
+			return nil, fmt.Errorf("inconsistent allele frequencies in binary multigenome")
+		}
+		f.setFreqs(le32s(afrq))
+	}
+	if ioff, ok := sec["IOFF"]; ok {
+		f.ioff, f.iblb = le32s(ioff), sec["IBLB"]
+		if len(f.ioff) != len(f.aoff) || f.ioff[0] != 0 || int(f.ioff[len(f.ioff)-1]) != len(f.iblb) {
+			return nil, fmt.Errorf("inconsistent allele IDs in binary multigenome")
+		}
+		for i := 1; i < len(f.ioff); i++ {
+			if f.ioff[i] < f.ioff[i-1] {
+				return nil, fmt.Errorf("inconsistent allele IDs in binary multigenome")
+			}
+		}
+	}
+	// The arrays are checked even if the checksum is not: they are indexed without bounds checks
+	// of their own, and checking them only reads the profile, not the sequence.
+	for k := range f.sites {
+		if (k > 0 && f.sites[k] <= f.sites[k-1]) || f.aidx[k+1] < f.aidx[k] {
+			return nil, fmt.Errorf("inconsistent SNP profile in binary multigenome")
+		}
+		if ref := f.siteRef(k); ref >= int(f.aidx[k+1]-f.aidx[k]) {
+			return nil, fmt.Errorf("inconsistent SNP profile in binary multigenome")
+		}
+	}
+	for i := 1; i < len(f.aoff); i++ {
+		if f.aoff[i] < f.aoff[i-1] {
+			return nil, fmt.Errorf("inconsistent SNP profile in binary multigenome")
+		}
+	}
+	return newChecked(seq, f, contigs, meta)
+}
+
+func newChecked(seq []byte, profile *Profile, contigs []Contig, meta map[string]string) (*Multigenome, error) {
+	mg, err := New(seq, profile, contigs)
+	if err != nil {
+		return nil, err
+	}
+	mg.meta = meta
+	return mg, nil
+}
+
+// le32s returns a little-endian uint32 array. On little-endian machines it uses b in place.
+func le32s(b []byte) []uint32 {
+	if a := u32view(b); a != nil {
+		return a
+	}
+	a := make([]uint32, len(b)/4)
+	for i := range a {
+		a[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+	return a
+}
+
+//-------------------------------------------------------------------------------------------------
+// Importing text files.
+//-------------------------------------------------------------------------------------------------
+
+// Import loads a multigenome saved in the text format (starred genome and SNP profile files).
+// If dict_file is empty, the files are not verified and the multigenome is a single unnamed contig,
+// as for files saved before sequence dictionaries were introduced.
+func Import(genome_file, snp_file, dict_file string) (*Multigenome, error) {
+	if dict_file != "" {
+		return Load(genome_file, snp_file, dict_file)
+	}
+	multi, err := readMulti(genome_file)
+	if err != nil && err != errNoFooter {
+		return nil, err
+	}
+	alleles, _, err := readSNPLocation(snp_file)
+	if err != nil && err != errNoFooter {
+		return nil, err
+	}
+	return New(multi, NewProfile(alleles), nil)
+}
@@ -0,0 +1,127 @@
+//----------------------------------------------------------------------------------------
+// Test for the binary multigenome format
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBinarySaveLoad(t *testing.T) {
+	defer __(o_())
+
+	mg, err := Build("test_data/toy.fasta", "test_data/toy.vcf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	bin_file := filepath.Join(dir, "genomestar.mgb")
+	if err = mg.SaveBinary(bin_file); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := LoadBinary(bin_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved.Seq(), mg.Seq()) {
+		t.Errorf("Fail loading sequence: %s", saved.Seq())
+	}
+	if !reflect.DeepEqual(saved.Contigs(), mg.Contigs()) {
+		t.Errorf("Fail loading contigs: %v", saved.Contigs())
+	}
+	if err = sameProfile(saved.Profile(), mg.Profile()); err != nil {
+		t.Errorf("Fail loading profile: %v", err)
+	}
+	if saved.Meta("fasta") != "test_data/toy.fasta" {
+		t.Errorf("Fail loading metadata: %v", saved.meta)
+	}
+
+	// Saving is deterministic.
+	bin_file2 := filepath.Join(dir, "genomestar2.mgb")
+	if err = saved.SaveBinary(bin_file2); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(bin_file)
+	data2, _ := ioutil.ReadFile(bin_file2)
+	if !bytes.Equal(data, data2) {
+		t.Errorf("Fail saving deterministically")
+	}
+	fmt.Println(len(data), "bytes")
+
+	// Corrupted and truncated files are rejected.
+	bad := append([]byte(nil), data...)
+	bad[len(bad)/2] ^= 1
+	ioutil.WriteFile(bin_file2, bad, 0644)
+	if _, err = LoadBinary(bin_file2); err == nil {
+		t.Errorf("Fail detecting corrupted file")
+	} else {
+		fmt.Println(err)
+	}
+	ioutil.WriteFile(bin_file2, data[:len(data)-8], 0644)
+	if _, err = LoadBinary(bin_file2); err == nil {
+		t.Errorf("Fail detecting truncated file")
+	} else {
+		fmt.Println(err)
+	}
+	ioutil.WriteFile(bin_file2, []byte("ACGT*ACGT"), 0644)
+	if _, err = LoadBinary(bin_file2); !errors.Is(err, ErrBadFormat) {
+		t.Errorf("Fail detecting text file: %v", err)
+	}
+}
+
+// sameProfile compares two profiles through their accessors.
+func sameProfile(p, q *Profile) error {
+	if !reflect.DeepEqual(p.Positions(), q.Positions()) {
+		return fmt.Errorf("positions %v, %v", p.Positions(), q.Positions())
+	}
+	for _, pos := range p.Positions() {
+		a, _ := p.Alleles(pos)
+		b, _ := q.Alleles(pos)
+		l, _ := p.SameLen(pos)
+		k, _ := q.SameLen(pos)
+		if !reflect.DeepEqual(a, b) || l != k || p.RefAllele(pos) != q.RefAllele(pos) {
+			return fmt.Errorf("site %d: %q %d %d, %q %d %d", pos, a, l, p.RefAllele(pos), b, k, q.RefAllele(pos))
+		}
+	}
+	return nil
+}
+
+func TestImportText(t *testing.T) {
+	defer __(o_())
+
+	dir := t.TempDir()
+	genome_file := filepath.Join(dir, "genomestar.txt")
+	snp_file := filepath.Join(dir, "SNPLocation.txt")
+	SNP_array := map[int]SNP{
+		7: {profile: []string{".", "A", "AT"}},
+		3: {profile: []string{"A", "C"}},
+	}
+	SaveMulti(genome_file, buildMultigenome2(SNP_array, []byte("ACGTACGTAC")))
+	SaveSNPLocation(snp_file, SNP_array)
+
+	// SNP locations are saved in position order, with alleles in profile order.
+	data, _ := ioutil.ReadFile(snp_file)
+	if string(data) != "#MULTIGENOME\t1\n3\tA\tC\n7\t.\tA\tAT\n#END\t15\t49261012\n" {
+		t.Errorf("Fail saving SNP locations: %q", data)
+	}
+
+	mg, err := Import(genome_file, snp_file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(mg.Seq()) != "ACG*ACG*AC" || mg.Profile().Len() != 2 {
+		t.Errorf("Fail importing text files: %s %d", mg.Seq(), mg.Profile().Len())
+	}
+	if _, err = Import(genome_file, filepath.Join(dir, "missing.txt"), ""); !os.IsNotExist(err) {
+		t.Errorf("Fail reporting missing file: %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: allele frequency module.
+// Allele frequencies of SNP profiles, read from the VCF INFO field or from a side file, and the
+// prior penalties which make the aligner prefer common alleles.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// minFreq is the frequency used for the prior penalty of alleles with frequency 0.
+const minFreq = 1e-6
+
+// unknownFreqs returns n unknown allele frequencies.
+func unknownFreqs(n int) []uint32 {
+	afrq := make([]uint32, n)
+	for i := range afrq {
+		afrq[i] = math.Float32bits(-1)
+	}
+	return afrq
+}
+
+// setFreqs sets the allele frequencies of a profile and computes the prior penalty of each allele,
+// 10*log10(fmax/f) for the largest known frequency fmax at its site. Alleles of unknown frequency
+// have no penalty.
+func (p *Profile) setFreqs(afrq []uint32) {
+	p.afrq = afrq
+	p.prior = make([]float32, len(afrq))
+	for k := range p.sites {
+		fmax := float32(0)
+		for a := p.aidx[k]; a < p.aidx[k+1]; a++ {
+			if f := math.Float32frombits(afrq[a]); f > fmax {
+				fmax = f
+			}
+		}
+		for a := p.aidx[k]; a < p.aidx[k+1] && fmax > 0; a++ {
+			f := math.Float32frombits(afrq[a])
+			if f < 0 {
+				continue
+			}
+			if f < minFreq {
+				f = minFreq
+			}
+			p.prior[a] = float32(10 * math.Log10(float64(fmax/f)))
+		}
+	}
+}
+
+// HasFreqs returns whether the profile has allele frequencies.
+func (p *Profile) HasFreqs() bool {
+	return p.afrq != nil
+}
+
+// Freqs returns the frequencies of the alleles at a genome position, negative if unknown, and
+// whether the position is a variant site of a profile with allele frequencies.
+func (p *Profile) Freqs(pos int) ([]float64, bool) {
+	k, ok := p.find(pos)
+	if !ok || p.afrq == nil {
+		return nil, false
+	}
+	f := make([]float64, p.numAlleles(k))
+	for i := range f {
+		f[i] = float64(p.alleleFreq(k, i))
+	}
+	return f, true
+}
+
+// WithFreqs returns a copy of the profile with allele frequencies given by genome position, in the
+// order of the alleles. Sites without frequencies have unknown frequencies.
+func (p *Profile) WithFreqs(freqs map[int][]float64) (*Profile, error) {
+	afrq := unknownFreqs(len(p.aoff) - 1)
+	for pos, f := range freqs {
+		k, ok := p.find(pos)
+		if !ok {
+			return nil, fmt.Errorf("allele frequencies at %d, which is not a variant site", pos)
+		}
+		if len(f) != p.numAlleles(k) {
+			return nil, fmt.Errorf("%d allele frequencies at %d, which has %d alleles", len(f), pos, p.numAlleles(k))
+		}
+		for i, v := range f {
+			afrq[int(p.aidx[k])+i] = math.Float32bits(float32(v))
+		}
+	}
+	q := *p
+	q.setFreqs(afrq)
+	return &q, nil
+}
+
+// SetAlleleFreqs sets the allele frequencies of a multigenome, see Profile.WithFreqs.
+func (mg *Multigenome) SetAlleleFreqs(freqs map[int][]float64) error {
+	p, err := mg.profile.WithFreqs(freqs)
+	if err != nil {
+		return err
+	}
+	mg.profile = p
+	return nil
+}
+
+// alleleFreq returns the frequency of the i-th allele of the k-th site, negative if unknown.
+func (p *Profile) alleleFreq(k, i int) float32 {
+	if p.afrq == nil {
+		return -1
+	}
+	return math.Float32frombits(p.afrq[int(p.aidx[k])+i])
+}
+
+// allelePrior returns the prior penalty of the i-th allele of the k-th site.
+func (p *Profile) allelePrior(k, i int) float32 {
+	if p.prior == nil {
+		return 0
+	}
+	return p.prior[int(p.aidx[k])+i]
+}
+
+// alleleIndex returns the index of an allele at the k-th site, or -1.
+func (p *Profile) alleleIndex(k int, allele []byte) int {
+	for i := 0; i < p.numAlleles(k); i++ {
+		if bytes.Equal(p.allele(k, i), allele) {
+			return i
+		}
+	}
+	return -1
+}
+
+//-------------------------------------------------------------------------------------------------
+// Allele priors in alignments.
+//-------------------------------------------------------------------------------------------------
+
+// priors returns whether the aligner adds prior penalties to allele costs.
+func (a *Aligner) priors() bool {
+	return a.cfg.PriorWeight != 0 && a.profile.prior != nil
+}
+
+// pen returns the prior penalty of the i-th allele of the k-th site, rounded to an integer cost.
+func (a *Aligner) pen(k, i int) int {
+	if !a.priors() {
+		return 0
+	}
+	return int(a.cfg.PriorWeight*float64(a.profile.allelePrior(k, i)) + 0.5)
+}
+
+// tracePen returns the prior penalty of an allele chosen at the k-th site.
+func (a *Aligner) tracePen(k int, allele []byte) int {
+	if !a.priors() {
+		return 0
+	}
+	if i := a.profile.alleleIndex(k, allele); i >= 0 {
+		return a.pen(k, i)
+	}
+	return 0
+}
+
+// prefer returns whether the i-th allele of the k-th site is more frequent than the j-th one, to
+// break ties between alleles of the same cost toward the common haplotype.
+func (a *Aligner) prefer(k, i, j int) bool {
+	return a.profile.afrq != nil && a.profile.alleleFreq(k, i) > a.profile.alleleFreq(k, j)
+}
+
+//-------------------------------------------------------------------------------------------------
+// Allele frequencies in VCF files and side files.
+//-------------------------------------------------------------------------------------------------
+
+// infoFreqs returns the allele frequencies of a VCF INFO field: the dbSNP CAF field (reference then
+// alternate alleles), or else the AF field (alternate alleles; the reference allele has the remaining
+// frequency). Missing values (".") are left out; it returns nil if there are no frequencies.
+func infoFreqs(info, ref string, alts []string) map[string]float64 {
+	var caf, af []string
+	for _, field := range strings.Split(info, ";") {
+		if strings.HasPrefix(field, "CAF=") {
+			caf = strings.Split(field[4:], ",")
+		} else if strings.HasPrefix(field, "AF=") {
+			af = strings.Split(field[3:], ",")
+		}
+	}
+	freq := make(map[string]float64)
+	if caf != nil {
+		alleles := append([]string{ref}, alts...)
+		for i, v := range caf {
+			if f, err := strconv.ParseFloat(v, 64); err == nil && i < len(alleles) {
+				freq[alleles[i]] = f
+			}
+		}
+	} else if af != nil {
+		sum, complete := 0.0, len(af) == len(alts)
+		for i, v := range af {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || i >= len(alts) {
+				complete = false
+				continue
+			}
+			freq[alts[i]] = f
+			sum += f
+		}
+		if complete && sum <= 1 {
+			freq[ref] = 1 - sum
+		}
+	}
+	if len(freq) == 0 {
+		return nil
+	}
+	return freq
+}
+
+// LoadAlleleFreqs loads allele frequencies saved by SaveAlleleFreqs: one line per site with its
+// position and the frequencies of its alleles, in the order of the SNP profile file.
+func LoadAlleleFreqs(file_name string) (map[int][]float64, error) {
+	f, err := os.Open(file_name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	freqs := make(map[int][]float64)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if sc.Text() == "" || strings.HasPrefix(sc.Text(), "#") {
+			continue
+		}
+		split := strings.Split(sc.Text(), "\t")
+		pos, err := strconv.Atoi(split[0])
+		if err != nil || len(split) < 2 {
+			return nil, fmt.Errorf("%s: bad line %q", file_name, sc.Text())
+		}
+		f := make([]float64, len(split)-1)
+		for i, v := range split[1:] {
+			if f[i], err = strconv.ParseFloat(v, 64); err != nil {
+				return nil, fmt.Errorf("%s: bad frequency %q", file_name, v)
+			}
+		}
+		freqs[pos] = f
+	}
+	if err = sc.Err(); err != nil {
+		return nil, err
+	}
+	return freqs, nil
+}
+
+// SaveAlleleFreqs saves the allele frequencies of a profile. The file is replaced atomically.
+func SaveAlleleFreqs(file_name string, p *Profile) error {
+	if !p.HasFreqs() {
+		return fmt.Errorf("SNP profile has no allele frequencies")
+	}
+	return writeFileAtomic(file_name, func(w *bufio.Writer) error {
+		for _, v := range p.Positions() {
+			f, _ := p.Freqs(v)
+			fmt.Fprintf(w, "%d", v)
+			for _, x := range f {
+				fmt.Fprintf(w, "\t%s", strconv.FormatFloat(x, 'g', -1, 32))
+			}
+			fmt.Fprintf(w, "\n")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,113 @@
+//----------------------------------------------------------------------------------------
+// Test for allele frequencies and priors
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestAlleleFreqs(t *testing.T) {
+	defer __(o_())
+
+	if f := infoFreqs("DB;CAF=0.9,.,0.1;AF=0.5", "A", []string{"C", "G"}); len(f) != 2 || f["A"] != 0.9 || f["G"] != 0.1 {
+		t.Errorf("Fail reading CAF: %v", f)
+	}
+	if f := infoFreqs("AF=0.25,0.5", "A", []string{"C", "G"}); len(f) != 3 || f["A"] != 0.25 || f["G"] != 0.5 {
+		t.Errorf("Fail reading AF: %v", f)
+	}
+	if f := infoFreqs("DP=10", "A", []string{"C"}); f != nil {
+		t.Errorf("Fail reading INFO without frequencies: %v", f)
+	}
+
+	// rs2 chrA:13 A>AT,. with AF=0.1,0.05; alleles are sorted: ".", "A", "AT"
+	mg, err := Build("test_data/toy.fasta", "test_data/toy.vcf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := mg.Profile().Freqs(12); !ok || fmt.Sprintf("%.2f", f) != "[0.05 0.85 0.10]" {
+		t.Errorf("Fail building allele frequencies: %v", f)
+	}
+
+	dir := t.TempDir()
+	bin_file := filepath.Join(dir, "toy.mgb")
+	freq_file := filepath.Join(dir, "toy.freq")
+	if err = mg.SaveBinary(bin_file); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := LoadBinary(bin_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := saved.Profile().Freqs(12); !ok || fmt.Sprintf("%.2f", f) != "[0.05 0.85 0.10]" {
+		t.Errorf("Fail loading binary allele frequencies: %v", f)
+	}
+	if err = SaveAlleleFreqs(freq_file, mg.Profile()); err != nil {
+		t.Fatal(err)
+	}
+	freqs, err := LoadAlleleFreqs(freq_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(freqs) != 5 || len(freqs[12]) != 3 || freqs[12][2] != 0.1 {
+		t.Errorf("Fail loading allele frequency file: %v", freqs)
+	}
+	if _, err = mg.Profile().WithFreqs(map[int][]float64{12: {0.5, 0.5}}); err == nil {
+		t.Errorf("Fail reporting wrong number of frequencies")
+	}
+	if _, err = mg.Profile().WithFreqs(map[int][]float64{13: {1}}); err == nil {
+		t.Errorf("Fail reporting frequencies outside variant sites")
+	}
+}
+
+func TestAllelePriors(t *testing.T) {
+	defer __(o_())
+
+	genome := []byte("ACC*CGT")
+	profile, err := NewProfile(type_snpprofile{3: {{'A'}, {'C'}, {'G'}}}).WithFreqs(map[int][]float64{3: {0.99, 0.01, -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var test_cases = []struct {
+		cfg      Config
+		read     string
+		d, prior int
+	}{
+		{DefaultConfig(), "ACCCCGT", 0, 0},
+		{Config{DistThres: INF, PriorWeight: 1}, "ACCACGT", 0, 0},
+		{Config{DistThres: INF, PriorWeight: 1}, "ACCCCGT", 20, 20},
+		{Config{DistThres: INF, PriorWeight: 0.5}, "ACCCCGT", 10, 10},
+		{Config{DistThres: INF, PriorWeight: 1}, "ACCGCGT", 0, 0},
+		{Config{DistThres: INF, PriorWeight: 1, Ins: 30, Del: 30}, "ACCCCGT", 20, 20},
+		{Config{DistThres: INF, PriorWeight: 1, AlleleMismatches: true}, "ACCTCGT", 1, 0},
+	}
+	for i, tc := range test_cases {
+		a := NewProfileAligner(profile, tc.cfg)
+		r := a.Backward([]byte(tc.read), genome, 0)
+		if r.Distance() != tc.d || r.Prior != tc.prior {
+			t.Errorf("Fail backward alignment with priors (case %d): got %d %d, want %d %d", i, r.Distance(), r.Prior, tc.d, tc.prior)
+		}
+		r = a.Forward([]byte(tc.read), genome, 0)
+		if r.Distance() != tc.d || r.Prior != tc.prior {
+			t.Errorf("Fail forward alignment with priors (case %d): got %d %d, want %d %d", i, r.Distance(), r.Prior, tc.d, tc.prior)
+		}
+	}
+
+	// Slices of the profile (as contig genomes) keep the penalties.
+	whole, _ := NewProfile(type_snpprofile{13: {{'A'}, {'C'}, {'G'}}}).WithFreqs(map[int][]float64{13: {0.99, 0.01, -1}})
+	sliced := whole.slice(10, 20)
+	if r := NewProfileAligner(sliced, Config{DistThres: INF, PriorWeight: 1}).Backward([]byte("ACCCCGT"), genome, 0); !sliced.HasFreqs() || r.Distance() != 20 || r.Prior != 20 {
+		t.Errorf("Fail alignment with priors of a sliced profile: got %d %d, want 20 20", r.Distance(), r.Prior)
+	}
+
+	// Ties between alleles are broken toward the common allele, also without penalties.
+	profile, _ = NewProfile(type_snpprofile{3: {{'C'}, {'G'}, {'T', 'T'}}}).WithFreqs(map[int][]float64{3: {0.2, 0.7, 0.1}})
+	a := NewProfileAligner(profile, Config{DistThres: INF, AlleleMismatches: true, Ins: 1, Del: 1})
+	if r := a.Backward([]byte("ACCACGT"), genome, 0); r.Distance() != 1 || string(r.Trace[3][3]) != "G" {
+		t.Errorf("Fail breaking ties toward the common allele: %d %q", r.Distance(), r.Trace[3][3])
+	}
+}
@@ -0,0 +1,262 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: gapped alignment module.
+// Dynamic programming with insertions and deletions outside the known variant sites, for reads from
+// samples with indels which are not in the SNP profile.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+// Edit operations of DP cells in gapped alignments, see Workspace.O.
+const (
+	opMatch = 'M' // a read base aligned to a genome base, or read bases aligned to an allele
+	opIns   = 'I' // a read base which is not in the genome
+	opDel   = 'D' // a genome base (or variant site) which is not in the read
+	opClip  = 'S' // the alignment starts at the cell, the read bases before it are soft-clipped
+)
+
+// Gap extension flags of DP cells in affine gapped alignments, see Workspace.X.
+const (
+	extIns = 1 // the best insertion ending at the cell extends an insertion ending at the cell above
+	extDel = 2 // the best deletion ending at the cell extends a deletion ending at the cell to the left
+)
+
+// gapped returns whether the aligner allows insertions and deletions outside variant sites.
+func (cfg *Config) gapped() bool {
+	return cfg.Ins > 0 || cfg.Del > 0
+}
+
+// gappedDistance calculates the distance between a read and a part of a multigenome with insertions
+// and deletions. The whole read is aligned by dynamic programming: the alignment is free at the free
+// end of the genome part and anchored at the other end, as in distance.
+// Read bases left over at the free end are insertions, or soft-clipped (see leadClip).
+// Gaps have affine costs (Gotoh): a gap of l bases costs GapOpen + l*Ins or GapOpen + l*Del. Known
+// indel alleles of the profile are not gaps and keep their cost.
+func (a *Aligner) gappedDistance(w *Workspace, v dpView) Result {
+	p := a.profile
+	sc := a.scorer()
+	a.window(w, &v)
+	m, n := len(v.s), len(v.t)
+	ins, del, open := a.cfg.Ins, a.cfg.Del, a.cfg.GapOpen
+	if ins <= 0 {
+		ins = 1000 * INF
+	}
+	if del <= 0 {
+		del = 1000 * INF
+	}
+	clearCalls(w.calls)
+
+	// H is the distance of the best alignment of each prefix pair, E and F of the best alignment
+	// ending with an insertion and a deletion; E is kept for the previous row, F for the previous cell.
+	// H is stored by rows of n+1 distances, T, O and X by rows of n cells (see Workspace).
+	w.resize(m, n)
+	H, E, T, O, X := w.D, w.E, w.T, w.O, w.X
+	for j := 0; j <= n; j++ {
+		H[j], E[j] = 0, 1000*INF
+	}
+	for i := 1; i <= m; i++ {
+		H[i*(n+1)] = open + i*ins
+		if a.leadClip(i) {
+			H[i*(n+1)] = a.clipCost(i)
+		}
+	}
+
+	// Columns 1..kern have no variant sites; with unit costs they are aligned by the bit-parallel
+	// kernel, which gives the whole column kern, and the DP starts at column kern+1. The later runs
+	// without sites are aligned by the kernel row by row (see myersRow), which gives their first
+	// and last columns; the DP aligns the columns around the sites from them. The runs are aligned
+	// outside the band too, which only adds alignments over the threshold.
+	kern := a.myersColumns(&v)
+	if kern > 0 {
+		a.myers(w, &v, kern, H, n+1)
+	}
+	a.myersRuns(w, &v, kern)
+	runs, bounded := w.runs, a.cfg.DistThres < INF
+	// An alignment through row i reaches column kern at row i or below, so its distance is at least
+	// w_min[i], the smallest distance in column kern from row i.
+	w.w_min = growInts(w.w_min, m+2)
+	w_min := w.w_min
+	w_min[m+1] = 1000 * INF
+	for i := m; i >= 0; i-- {
+		w_min[i] = w_min[i+1]
+		if H[i*(n+1)+kern] < w_min[i] {
+			w_min[i] = H[i*(n+1)+kern]
+		}
+	}
+
+	gi, gd := a.gaps(a.cfg.DistThres, m, n)
+	b := a.band(w, &v, m, n, gi, gd)
+	clip := a.cfg.clipped()
+	var cost, site, d, f, best, row_min, over, c, u int
+	var op, x byte
+	var allele []byte
+	var Hi, Hp []int // rows i and i-1
+	for i := 1; i <= m; i++ {
+		Hi, Hp = H[i*(n+1):(i+1)*(n+1)], H[(i-1)*(n+1):i*(n+1)]
+		for j := kern + 1; j <= n; j++ {
+			if j < b.lo[i] || j > b.hi[i] {
+				Hi[j], E[j] = 1000*INF, 1000*INF
+			}
+		}
+		f = 1000 * INF
+		row_min = w_min[i]
+		lo := b.lo[i]
+		if lo <= kern {
+			lo = kern + 1
+		}
+		// The runs before the band, in it and after it are advanced in the order of their columns;
+		// only their columns in the band count for abandoning the alignment.
+		for u = 0; u < len(runs) && runs[u].e < lo; u++ {
+			w.myersRow(&v, runs[u], i, Hi, Hp)
+		}
+		for j := lo; j <= b.hi[i]; j++ {
+			if u < len(runs) && j >= runs[u].s {
+				w.myersRow(&v, runs[u], i, Hi, Hp)
+				if bounded {
+					if d = w.myersMin(runs[u], Hi, j, b.hi[i]); d < row_min {
+						row_min = d
+					}
+				}
+				j, f = runs[u].e, 1000*INF
+				u++
+				continue
+			}
+			c, x = (i-1)*n+j-1, 0
+			if E[j]+ins < Hp[j]+open+ins {
+				E[j] = E[j] + ins
+				x |= extIns
+			} else {
+				E[j] = Hp[j] + open + ins
+			}
+			if f+del < Hi[j-1]+open+del {
+				f = f + del
+				x |= extDel
+			} else {
+				f = Hi[j-1] + open + del
+			}
+			d, op = E[j], byte(opIns)
+			if f < d {
+				d, op = f, opDel
+			}
+			site = int(v.win[j])
+			if site < 0 {
+				cost = Hp[j-1]
+				if v.base(i) != v.ref(j) {
+					cost += v.sub(sc, i, j)
+				}
+				if cost <= d {
+					d, op = cost, opMatch
+				}
+			} else {
+				for k := 0; k < p.numAlleles(site); k++ {
+					allele = p.allele(site, k)
+					if allele[0] == '.' {
+						cost = Hi[j-1]
+					} else if i >= len(allele) {
+						cost = H[(i-len(allele))*(n+1)+j-1] + v.allele(sc, i, allele)
+					} else {
+						continue
+					}
+					cost += a.pen(site, k)
+					if cost < d || (cost == d && (op != opMatch || a.prefer(site, k, best))) {
+						d, op, best = cost, opMatch, k
+						T[c] = uint16(k + 1)
+					}
+				}
+			}
+			// The alignment may start at the cell after clipping the read bases of rows 1..i.
+			if clip && a.clipCost(i) < d {
+				d, op = a.clipCost(i), opClip
+			}
+			Hi[j], O[c], X[c] = d, op, x
+			if d < row_min {
+				row_min = d
+			}
+		}
+		for ; u < len(runs); u++ {
+			w.myersRow(&v, runs[u], i, Hi, Hp)
+		}
+		if a.abandon(b, row_min, a.cfg.DistThres, &over) {
+			return a.abandoned(w, m, n)
+		}
+	}
+	end_i, end_j, dist := m, n, H[m*(n+1)+n]
+	if a.cfg.Mode == ModeLocal {
+		end_i, end_j, dist = a.clipEnd(H, m, n)
+	}
+	if dist >= INF {
+		return Result{DPDist: INF, M: m, N: n, Calls: w.calls, Trace: [][][]byte{}, OK: true}
+	}
+	r := Result{DPDist: dist, M: m, N: n, Calls: w.calls, ws: w, kern: kern, OK: true, AnchorClip: m - end_i, skip: n - end_j}
+	if a.priors() || clip {
+		r.Prior, r.FreeClip = a.gappedTraceBack(v, r, nil)
+	}
+	return r
+}
+
+// gappedTraceBack follows the edit operations of a gapped alignment and adds the read bases aligned
+// to the variant sites to snp_calling, if it is not nil; it returns the sum of the prior penalties
+// of the chosen alleles and the number of read bases soft-clipped at the free end. Sites deleted
+// from the read are called as empty. It stops at the leading columns aligned by the bit-parallel
+// kernel, which have no variant sites, and crosses its later runs with runPath.
+func (a *Aligner) gappedTraceBack(v dpView, r Result, snp_calling map[int][]byte) (int, int) {
+	var prior int
+	if snp_calling != nil {
+		for k, val := range r.Calls {
+			snp_calling[k] = val
+		}
+	}
+	var snp_len int
+	var ext byte
+	var allele []byte
+	O, X := r.ws.O, r.ws.X
+	op := byte(opMatch) // the matrix of the current cell: opMatch for H, opIns for E, opDel for F
+	i, j := r.end()
+	for i > 0 && j > r.kern {
+		if op == opMatch {
+			if s := r.runStart(j); s > 0 {
+				i, j = a.runPath(nil, &v, r, s, i, j)
+				continue
+			}
+			op = O[(i-1)*r.N+j-1]
+		}
+		switch op {
+		case opClip:
+			return prior, i
+		case opIns:
+			ext = X[(i-1)*r.N+j-1] & extIns
+			i--
+			if ext == 0 {
+				op = opMatch
+			}
+		case opDel:
+			ext = X[(i-1)*r.N+j-1] & extDel
+			if v.win[j] >= 0 && snp_calling != nil {
+				snp_calling[v.gpos(j)] = v.seg(i, 0)
+			}
+			j--
+			if ext == 0 {
+				op = opMatch
+			}
+		default:
+			if v.win[j] < 0 {
+				i, j = i-1, j-1
+				continue
+			}
+			if allele = r.allele(a.profile, &v, i, j); allele[0] != '.' {
+				snp_len = len(allele)
+			} else {
+				snp_len = 0
+			}
+			if snp_calling != nil {
+				snp_calling[v.gpos(j)] = v.seg(i, snp_len)
+			}
+			prior += a.tracePen(int(v.win[j]), allele)
+			i, j = i-snp_len, j-1
+		}
+	}
+	if j == 0 && a.leadClip(i) {
+		return prior, i
+	}
+	return prior, 0
+}
@@ -0,0 +1,66 @@
+//----------------------------------------------------------------------------------------
+// Test for gapped alignment
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Test for alignment with insertions and deletions outside variant sites
+func TestAlignerGapped(t *testing.T) {
+	defer __(o_())
+
+	var test_cases = []struct {
+		profile           type_snpprofile
+		genome, read      string
+		ins, del, open, d int
+		site              int
+		call              string
+	}{
+		{type_snpprofile{}, "ACGTACGTAC", "ACGTCGTAC", 1, 1, 0, 1, -1, ""},
+		{type_snpprofile{}, "ACGTACGTAC", "ACGTTACGTAC", 1, 1, 0, 1, -1, ""},
+		{type_snpprofile{}, "ACGTACGTAC", "ACGTTACGTAC", 2, 1, 0, 2, -1, ""},
+		{type_snpprofile{3: {{'A'}, {'C'}}}, "ACC*CGTACG", "ACCACGACG", 1, 1, 0, 1, 3, "A"},
+		{type_snpprofile{3: {{'A'}, {'C'}}}, "ACC*CGTACG", "ACCCGTACG", 1, 1, 0, 1, 3, "C"},
+		{type_snpprofile{3: {{'A'}, {'C'}, {'.'}}}, "ACC*CGTACG", "ACCCGTACG", 1, 1, 0, 0, 3, ""},
+		{type_snpprofile{3: {{'T'}, {'T', 'T', 'A'}}}, "ACC*CGTACG", "ACCTTACGTTACG", 1, 1, 0, 1, 3, "TTA"},
+		// affine gaps
+		{type_snpprofile{}, "ACGTCAAAAAAGTCGTAC", "ACGTCAAAAGTCGTAC", 1, 1, 2, 4, -1, ""},
+		{type_snpprofile{}, "ACGTACGTCAGTCGTAC", "ACGTCGTCAGTCTAC", 1, 1, 2, 6, -1, ""},
+		{type_snpprofile{3: {{'A'}, {'C'}, {'.'}}}, "ACC*CGTACG", "ACCCGTACG", 1, 1, 2, 0, 3, ""},
+		{type_snpprofile{3: {{'T'}, {'T', 'T', 'A'}}}, "ACC*CGTACG", "ACCTTACGTTTACG", 1, 1, 2, 4, 3, "TTA"},
+		{type_snpprofile{12: {{'A'}, {'C'}}}, "ACGTCAAAAAAG*TCGTAC", "ACGTCAAAAGCTCGTAC", 1, 1, 2, 4, 12, "C"},
+		{type_snpprofile{5: {{'A'}, {'C'}}}, "ACGTC*AAAAAAGTCGTAC", "ACGTCCAAAAGTCGTAC", 1, 1, 2, 4, 5, "C"},
+	}
+	for i, tc := range test_cases {
+		a := NewProfileAligner(NewProfile(tc.profile), Config{DistThres: INF, Ins: tc.ins, Del: tc.del, GapOpen: tc.open})
+		read, genome := []byte(tc.read), []byte(tc.genome)
+		r := a.Backward(read, genome, 0)
+		if r.Distance() != tc.d {
+			t.Errorf("Fail gapped backward alignment (case %d): got %d, want %d", i, r.Distance(), tc.d)
+		}
+		snp := a.BackwardTraceBack(read, genome, r, 0)
+		if call, ok := snp[tc.site]; tc.site >= 0 && (!ok || string(call) != tc.call) {
+			t.Errorf("Fail gapped backward traceback (case %d): %v", i, snp)
+		}
+		r = a.Forward(read, genome, 0)
+		if r.Distance() != tc.d {
+			t.Errorf("Fail gapped forward alignment (case %d): got %d, want %d", i, r.Distance(), tc.d)
+		}
+		snp = a.ForwardTraceBack(read, genome, r, 0)
+		if call, ok := snp[tc.site]; tc.site >= 0 && (!ok || string(call) != tc.call) {
+			t.Errorf("Fail gapped forward traceback (case %d): %v", i, snp)
+		}
+		fmt.Println(i, r.Distance(), snp)
+	}
+
+	// Without gaps, a novel deletion is aligned with mismatches.
+	a := NewProfileAligner(NewProfile(type_snpprofile{}), DefaultConfig())
+	if r := a.Backward([]byte("ACGTCGTAC"), []byte("ACGTACGTAC"), 0); r.Distance() <= 1 {
+		t.Errorf("Fail ungapped alignment: %d", r.Distance())
+	}
+}
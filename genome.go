@@ -0,0 +1,319 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: multigenome type.
+// A starred multigenome together with its SNP profile, contig layout and metadata.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Multigenome is a reference genome in which variant sites are replaced by "*" characters,
+// together with the profile of alleles at these sites.
+type Multigenome struct {
+	seq     []byte
+	profile *Profile
+	contigs []Contig
+	meta    map[string]string
+	unmap   func() error // releases the memory mapping of the multigenome, see MapBinary
+}
+
+// New creates a multigenome from a starred sequence, its profile and its contigs.
+// If profile is nil, the multigenome has no variant sites; if contigs is nil, the sequence is a single
+// unnamed contig.
+func New(seq []byte, profile *Profile, contigs []Contig) (*Multigenome, error) {
+	if profile == nil {
+		profile = NewProfile(make(map[int][][]byte))
+	}
+	if contigs == nil {
+		contigs = []Contig{{Name: "", Offset: 0, Len: len(seq)}}
+	}
+	mg := &Multigenome{seq: seq, profile: profile, contigs: contigs, meta: make(map[string]string)}
+	if err := mg.check(); err != nil {
+		return nil, err
+	}
+	return mg, nil
+}
+
+// Build creates a multigenome from a reference genome (FASTA file) and dbSNPs (VCF file).
+func Build(fasta_file, vcf_file string) (*Multigenome, error) {
+	seq, contigs, err := readFasta(fasta_file)
+	if err != nil {
+		return nil, err
+	}
+	SNP_arr, err := readVCF(vcf_file, contigs)
+	if err != nil {
+		return nil, err
+	}
+	mg, err := New(buildMultigenome2(SNP_arr, seq), newProfileSNP(SNP_arr), contigs)
+	if err != nil {
+		return nil, err
+	}
+	mg.meta["fasta"] = fasta_file
+	mg.meta["vcf"] = vcf_file
+	return mg, nil
+}
+
+// Load loads a multigenome saved by Save, and verifies it against its sequence dictionary.
+func Load(genome_file, snp_file, dict_file string) (*Multigenome, error) {
+	d, err := LoadSeqDict(dict_file)
+	if err != nil {
+		return nil, err
+	}
+	multi, alleles, _, err := LoadMultiChecked(genome_file, snp_file, dict_file)
+	if err != nil {
+		return nil, err
+	}
+	return newFromDict(multi, alleles, d)
+}
+
+// Read reads a multigenome written by Write, and verifies it against its sequence dictionary.
+func Read(genome, snp, dict io.Reader) (*Multigenome, error) {
+	d, err := ReadSeqDict(dict)
+	if err != nil {
+		return nil, err
+	}
+	multi, err := ReadMulti(genome)
+	if err != nil {
+		return nil, err
+	}
+	alleles, _, err := ReadSNPLocation(snp)
+	if err != nil {
+		return nil, err
+	}
+	if err = d.Verify(multi, alleles); err != nil {
+		return nil, err
+	}
+	return newFromDict(multi, alleles, d)
+}
+
+// newFromDict creates a multigenome with the contigs and metadata of a sequence dictionary.
+func newFromDict(multi []byte, alleles map[int][][]byte, d *SeqDict) (*Multigenome, error) {
+	profile := NewProfile(alleles)
+	if err := profile.setRefs(d.Refs); err != nil {
+		return nil, err
+	}
+	mg, err := New(multi, profile, d.Contigs)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range d.Meta {
+		mg.meta[k] = v
+	}
+	return mg, nil
+}
+
+// Save saves the starred sequence, the SNP profile and the sequence dictionary of a multigenome.
+// Reference alleles are not kept in the SNP profile file.
+func (mg *Multigenome) Save(genome_file, snp_file, dict_file string) error {
+	SNP_arr, d := mg.textFiles()
+	if err := SaveMulti(genome_file, mg.seq); err != nil {
+		return err
+	}
+	if err := SaveSNPLocation(snp_file, SNP_arr); err != nil {
+		return err
+	}
+	return SaveSeqDict(dict_file, d)
+}
+
+// Write writes the starred sequence, the SNP profile and the sequence dictionary of a multigenome
+// in the formats of Save.
+func (mg *Multigenome) Write(genome, snp, dict io.Writer) error {
+	SNP_arr, d := mg.textFiles()
+	if err := WriteMulti(genome, mg.seq); err != nil {
+		return err
+	}
+	if err := WriteSNPLocation(snp, SNP_arr); err != nil {
+		return err
+	}
+	return WriteSeqDict(dict, d)
+}
+
+// textFiles returns the SNP profile and the sequence dictionary saved with a multigenome.
+func (mg *Multigenome) textFiles() (map[int]SNP, *SeqDict) {
+	SNP_arr := make(map[int]SNP, mg.profile.Len())
+	refs := make(map[int]int)
+	all := mg.profile.allelesMap()
+	for pos, alleles := range all {
+		t := make([]string, len(alleles))
+		for i, v := range alleles {
+			t[i] = string(v)
+		}
+		SNP_arr[pos] = SNP{profile: t}
+	}
+	for k, pos := range mg.profile.sites {
+		if ref := mg.profile.siteRef(k); ref >= 0 {
+			refs[int(pos)] = ref
+		}
+	}
+	d := &SeqDict{
+		Contigs:    mg.contigs,
+		GenomeMD5:  md5Hex(mg.seq),
+		ProfileMD5: profileMD5(all),
+		Refs:       refs,
+		Meta:       mg.meta,
+	}
+	return SNP_arr, d
+}
+
+// check verifies that the sequence, profile and contigs of a multigenome are consistent.
+func (mg *Multigenome) check() error {
+	offset := 0
+	for _, c := range mg.contigs {
+		if c.Offset != offset || c.Len < 0 {
+			return fmt.Errorf("contig %q at offset %d with length %d does not follow the previous contig", c.Name, c.Offset, c.Len)
+		}
+		offset += c.Len
+	}
+	if offset != len(mg.seq) {
+		return fmt.Errorf("contigs cover %d bases, multigenome has %d", offset, len(mg.seq))
+	}
+	for _, pos := range mg.profile.Positions() {
+		if pos < 0 || pos >= len(mg.seq) {
+			return fmt.Errorf("SNP position %d is outside the multigenome", pos)
+		}
+		if mg.seq[pos] != '*' {
+			return fmt.Errorf("SNP position %d is not a \"*\" site", pos)
+		}
+	}
+	return nil
+}
+
+//-------------------------------------------------------------------------------------------------
+// Accessors.
+//-------------------------------------------------------------------------------------------------
+
+// Len returns the length of the multigenome.
+func (mg *Multigenome) Len() int {
+	return len(mg.seq)
+}
+
+// Seq returns the starred sequence. It must not be modified.
+func (mg *Multigenome) Seq() []byte {
+	return mg.seq
+}
+
+// Segment returns the starred sequence in [start, end). It must not be modified.
+// A segment which runs past the end of a circular contig continues at its start, in a copy.
+func (mg *Multigenome) Segment(start, end int) []byte {
+	if c, ok := mg.ContigAt(start); ok && c.Circular {
+		return wrapSegment(mg.seq, c, start, end)
+	}
+	return mg.seq[start:end]
+}
+
+// wrapSegment returns seq in [start, end), continued at the start of the circular contig c, which
+// contains start, after its end.
+func wrapSegment(seq []byte, c Contig, start, end int) []byte {
+	if end <= c.Offset+c.Len {
+		return seq[start:end]
+	}
+	seg := make([]byte, 0, end-start)
+	seg = append(seg, seq[start:c.Offset+c.Len]...)
+	for len(seg) < end-start {
+		l := end - start - len(seg)
+		if l > c.Len {
+			l = c.Len
+		}
+		seg = append(seg, seq[c.Offset:c.Offset+l]...)
+	}
+	return seg
+}
+
+// Wrap returns the genome position j bases after pos, which continues at the start of a circular
+// contig after its end.
+func (mg *Multigenome) Wrap(pos, j int) int {
+	c, ok := mg.ContigAt(pos)
+	if !ok || !c.Circular || pos+j < c.Offset+c.Len {
+		return pos + j
+	}
+	return c.Offset + (pos+j-c.Offset)%c.Len
+}
+
+// Profile returns the SNP profile.
+func (mg *Multigenome) Profile() *Profile {
+	return mg.profile
+}
+
+// Variants returns the alleles at a genome position, and whether the position is a variant site.
+func (mg *Multigenome) Variants(pos int) ([][]byte, bool) {
+	return mg.profile.Alleles(pos)
+}
+
+// Contigs returns the contigs of the multigenome in genome order.
+func (mg *Multigenome) Contigs() []Contig {
+	return mg.contigs
+}
+
+// Contig returns the contig with a given name.
+func (mg *Multigenome) Contig(name string) (Contig, bool) {
+	for _, c := range mg.contigs {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Contig{}, false
+}
+
+// ContigAt returns the contig containing a genome position.
+func (mg *Multigenome) ContigAt(pos int) (Contig, bool) {
+	i := sort.Search(len(mg.contigs), func(i int) bool {
+		return mg.contigs[i].Offset+mg.contigs[i].Len > pos
+	})
+	if pos < 0 || i == len(mg.contigs) {
+		return Contig{}, false
+	}
+	return mg.contigs[i], true
+}
+
+// SetCircular flags a contig as circular or linear.
+func (mg *Multigenome) SetCircular(name string, circular bool) error {
+	for k := range mg.contigs {
+		if mg.contigs[k].Name == name {
+			mg.contigs[k].Circular = circular
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown contig %q", name)
+}
+
+// Meta returns a metadata value, such as the FASTA ("fasta") and VCF ("vcf") files it was built from.
+func (mg *Multigenome) Meta(key string) string {
+	return mg.meta[key]
+}
+
+// SetMeta sets a metadata value, which is saved with the multigenome.
+func (mg *Multigenome) SetMeta(key, value string) {
+	mg.meta[key] = value
+}
+
+//-------------------------------------------------------------------------------------------------
+// Alignment.
+// The read is aligned to the multigenome segment [start, end) with the default aligner parameters,
+// see Aligner for the results and for other parameters. Segments may run past the end of circular
+// contigs, see Segment.
+//-------------------------------------------------------------------------------------------------
+
+// BackwardDistance calculates the distance between a read and a multigenome segment in backward direction.
+func (mg *Multigenome) BackwardDistance(read []byte, start, end int) Result {
+	return NewAligner(mg, DefaultConfig()).Backward(read, mg.Segment(start, end), start)
+}
+
+// BackwardTraceBack constructs the alignment found by BackwardDistance.
+func (mg *Multigenome) BackwardTraceBack(read []byte, start, end int, r Result) map[int][]byte {
+	return NewAligner(mg, DefaultConfig()).BackwardTraceBack(read, mg.Segment(start, end), r, start)
+}
+
+// ForwardDistance calculates the distance between a read and a multigenome segment in forward direction.
+func (mg *Multigenome) ForwardDistance(read []byte, start, end int) Result {
+	return NewAligner(mg, DefaultConfig()).Forward(read, mg.Segment(start, end), start)
+}
+
+// ForwardTraceBack constructs the alignment found by ForwardDistance.
+func (mg *Multigenome) ForwardTraceBack(read []byte, start, end int, r Result) map[int][]byte {
+	return NewAligner(mg, DefaultConfig()).ForwardTraceBack(read, mg.Segment(start, end), r, start)
+}
@@ -0,0 +1,230 @@
+//----------------------------------------------------------------------------------------
+// Test for the multigenome type
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestMultigenomeBuildLoad(t *testing.T) {
+	defer __(o_())
+
+	mg, err := Build("test_data/toy.fasta", "test_data/toy.vcf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(mg.Seq()) != "AC*TACGTACGT*CGTTTA*CCGG"+"TTG*CCAT*ACA" {
+		t.Errorf("Fail building multigenome: %s", string(mg.Seq()))
+	}
+	if alleles, ok := mg.Variants(12); !ok || len(alleles) != 3 || mg.Profile().RefAllele(12) != 1 {
+		t.Errorf("Fail building profile at 12: %q %d", alleles, mg.Profile().RefAllele(12))
+	}
+	if c, ok := mg.ContigAt(32); !ok || c.Name != "chrB" {
+		t.Errorf("Fail finding contig at 32: %v", c)
+	}
+	if c, ok := mg.Contig("chrB"); !ok || c.Offset != 24 {
+		t.Errorf("Fail finding contig chrB: %v", c)
+	}
+	// CHROM names must match the contigs exactly.
+	for _, c := range [][]Contig{mg.Contigs(), mg.Contigs()[:1]} {
+		vcf_file := filepath.Join(t.TempDir(), "chrom.vcf")
+		ioutil.WriteFile(vcf_file, []byte("A\t3\trs1\tG\tA\n"), 0644)
+		if _, err := readVCF(vcf_file, c); err == nil {
+			t.Errorf("Fail reporting an unknown CHROM name with contigs %v", c)
+		}
+	}
+
+	dir := t.TempDir()
+	genome_file := filepath.Join(dir, "genomestar.txt")
+	snp_file := filepath.Join(dir, "SNPLocation.txt")
+	dict_file := filepath.Join(dir, "genomestar.dict")
+	if err = mg.Save(genome_file, snp_file, dict_file); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Load(genome_file, snp_file, dict_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(saved.Seq()) != string(mg.Seq()) || saved.Profile().Len() != 5 || len(saved.Contigs()) != 2 {
+		t.Errorf("Fail loading multigenome: %s %d %v", saved.Seq(), saved.Profile().Len(), saved.Contigs())
+	}
+	if err = sameProfile(saved.Profile(), mg.Profile()); err != nil {
+		t.Errorf("Fail loading profile: %v", err)
+	}
+	if saved.Meta("vcf") != "test_data/toy.vcf" {
+		t.Errorf("Fail loading metadata: %q", saved.Meta("vcf"))
+	}
+	fmt.Println(saved.Contigs())
+}
+
+func TestMultigenomeReadWrite(t *testing.T) {
+	defer __(o_())
+
+	mg, err := Build("test_data/toy.fasta", "test_data/toy.vcf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var genome, snp, dict bytes.Buffer
+	zw := gzip.NewWriter(&genome)
+	if err = mg.Write(zw, &snp, &dict); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+	zr, err := gzip.NewReader(&genome)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Read(zr, bytes.NewReader(snp.Bytes()), bytes.NewReader(dict.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(saved.Seq()) != string(mg.Seq()) || saved.Profile().Len() != 5 || len(saved.Contigs()) != 2 {
+		t.Errorf("Fail reading multigenome: %s %d %v", saved.Seq(), saved.Profile().Len(), saved.Contigs())
+	}
+
+	// A modified profile is rejected.
+	other := snp.Bytes()
+	other = bytes.Replace(other, []byte("\tC\n"), []byte("\tT\n"), 1)
+	if _, err = Read(bytes.NewReader([]byte(mg.Seq())), bytes.NewReader(other), bytes.NewReader(dict.Bytes())); err == nil {
+		t.Errorf("Fail detecting modified profile")
+	}
+
+	var bin bytes.Buffer
+	if err = mg.WriteBinary(&bin); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err = ReadBinary(&bin); err != nil || string(saved.Seq()) != string(mg.Seq()) {
+		t.Errorf("Fail reading binary multigenome: %v", err)
+	}
+}
+
+func TestMultigenomeAlignment(t *testing.T) {
+	defer __(o_())
+
+	mg, err := Build("test_data/toy.fasta", "test_data/toy.vcf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := []byte("ACGTACGTATCGTTTA")
+	r := mg.BackwardDistance(read, 4, 19)
+	if r.Distance() != 0 {
+		t.Errorf("Fail backward alignment: %d", r.Distance())
+	}
+	snp := mg.BackwardTraceBack(read, 4, 19, r)
+	if string(snp[12]) != "AT" {
+		t.Errorf("Fail backward traceback: %v", snp)
+	}
+	r = mg.ForwardDistance(read, 4, 19)
+	if r.Distance() != 0 {
+		t.Errorf("Fail forward alignment: %d", r.Distance())
+	}
+	snp = mg.ForwardTraceBack(read, 4, 19, r)
+	if string(snp[12]) != "AT" {
+		t.Errorf("Fail forward traceback: %v", snp)
+	}
+}
+
+func TestMultigenomeCircular(t *testing.T) {
+	defer __(o_())
+
+	mg, err := Build("test_data/toy.fasta", "test_data/toy.vcf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = mg.SetCircular("chrC", true); err == nil {
+		t.Errorf("Fail reporting an unknown contig")
+	}
+	if err = mg.SetCircular("chrB", true); err != nil {
+		t.Fatal(err)
+	}
+	// chrB is TTG*CCAT*ACA at 24..35, with sites 27 (A, G) and 32 (G, GA).
+	if seg := string(mg.Segment(30, 40)); seg != "AT*ACATTG*" {
+		t.Errorf("Fail wrapping segment: %s", seg)
+	}
+	if seg := string(mg.Segment(4, 10)); seg != "ACGTAC" {
+		t.Errorf("Fail segment of a linear contig: %s", seg)
+	}
+	if pos := mg.Wrap(33, 4); pos != 25 {
+		t.Errorf("Fail wrapping position: %d", pos)
+	}
+	if pos := mg.Wrap(20, 4); pos != 24 {
+		t.Errorf("Fail position of a linear contig: %d", pos)
+	}
+
+	var genome, snp, dict bytes.Buffer
+	if err = mg.Write(&genome, &snp, &dict); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Read(&genome, &snp, &dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bin bytes.Buffer
+	if err = mg.WriteBinary(&bin); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadBinary(&bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*Multigenome{saved, loaded} {
+		if c := m.Contigs(); c[0].Circular || !c[1].Circular {
+			t.Errorf("Fail saving circular contigs: %v", c)
+		}
+	}
+
+	// Reads across the origin of chrB align in both directions, with the sites after the origin.
+	read := []byte("ATGAACATTGG")
+	for _, fwd := range []bool{false, true} {
+		r := mg.BackwardDistance(read, 30, 40)
+		calls := mg.BackwardTraceBack(read, 30, 40, r)
+		if fwd {
+			r = mg.ForwardDistance(read, 30, 40)
+			calls = mg.ForwardTraceBack(read, 30, 40, r)
+		}
+		if r.Distance() != 0 || len(calls) != 2 || string(calls[27]) != "G" || string(calls[32]) != "GA" {
+			t.Errorf("Fail alignment across the origin (forward %v): %d %v", fwd, r.Distance(), calls)
+		}
+	}
+	a := NewAligner(mg, DefaultConfig())
+	g := mg.Pack()
+	r := a.BackwardPacked(read, g, 30, 40)
+	if calls := a.BackwardTraceBackPacked(read, g, 30, 40, r); r.Distance() != 0 || string(calls[27]) != "G" || string(calls[32]) != "GA" {
+		t.Errorf("Fail backward alignment to packed multigenome across the origin: %d %v", r.Distance(), calls)
+	}
+	r = a.ForwardPacked(read, g, 30, 40)
+	if calls := a.ForwardTraceBackPacked(read, g, 30, 40, r); r.Distance() != 0 || string(calls[27]) != "G" || string(calls[32]) != "GA" {
+		t.Errorf("Fail forward alignment to packed multigenome across the origin: %d %v", r.Distance(), calls)
+	}
+	for _, seed := range []struct{ read_pos, genome_pos int }{{4, 33}, {7, 24}} {
+		e, err := a.ExtendSeed(read, mg.Seq(), seed.read_pos, seed.genome_pos, 3)
+		if err != nil || !e.OK || e.Dist != 0 || e.Pos != 30 || string(e.Calls[27]) != "G" || string(e.Calls[32]) != "GA" {
+			t.Errorf("Fail extending seed across the origin (%v): %d %v %v %v", seed, e.Dist, e.Alignment, e.Calls, err)
+		}
+	}
+
+	// Extensions across the origin are long enough for the deletion alleles after it.
+	seq := []byte("C*GTACCTAGGA")
+	mg, err = New(seq, newProfileSNP(map[int]SNP{1: {profile: []string{"A", "."}, ref: "A"}}), []Contig{{Name: "c", Len: len(seq), Circular: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a = NewAligner(mg, DefaultConfig())
+	for _, tc := range []struct {
+		read                         string
+		read_pos, genome_pos, al_pos int
+	}{{"GTACCTAGGACGT", 4, 6, 2}, {"AGGACGTACCT", 6, 3, 8}} {
+		e, err := a.ExtendSeed([]byte(tc.read), seq, tc.read_pos, tc.genome_pos, 3)
+		if call, ok := e.Calls[1]; err != nil || !e.OK || e.Dist != 0 || e.Pos != tc.al_pos || !ok || len(call) != 0 {
+			t.Errorf("Fail extending seed over a deletion across the origin (%v): %d %v %v %v", tc, e.Dist, e.Alignment, e.Calls, err)
+		}
+	}
+}
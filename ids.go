@@ -0,0 +1,47 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: variant ID module.
+// VCF IDs of the alleles of SNP profiles, so that alignments can report which known variants a
+// read carries.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+// packIDs returns the offsets and bytes of allele IDs, see Profile.ioff.
+func packIDs(ids []string) ([]uint32, []byte) {
+	ioff := make([]uint32, 1, len(ids)+1)
+	var iblb []byte
+	for _, id := range ids {
+		iblb = append(iblb, id...)
+		ioff = append(ioff, uint32(len(iblb)))
+	}
+	return ioff, iblb
+}
+
+// HasIDs returns whether the profile has allele IDs.
+func (p *Profile) HasIDs() bool {
+	return p.ioff != nil
+}
+
+// AlleleIDs returns the VCF IDs of the alleles at a genome position, "" if unknown, and whether
+// the position is a variant site of a profile with allele IDs.
+func (p *Profile) AlleleIDs(pos int) ([]string, bool) {
+	k, ok := p.find(pos)
+	if !ok || p.ioff == nil {
+		return nil, false
+	}
+	ids := make([]string, p.numAlleles(k))
+	for i := range ids {
+		ids[i] = p.alleleID(k, i)
+	}
+	return ids, true
+}
+
+// alleleID returns the ID of the i-th allele of the k-th site, "" if unknown.
+func (p *Profile) alleleID(k, i int) string {
+	if p.ioff == nil || i < 0 {
+		return ""
+	}
+	a := int(p.aidx[k]) + i
+	return string(p.iblb[p.ioff[a]:p.ioff[a+1]])
+}
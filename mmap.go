@@ -0,0 +1,56 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: memory-mapped loading module.
+// Binary multigenomes can be mapped into memory instead of being read, so that processes loading
+// the same file share the page cache and start without reading the whole file.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// MapBinary loads a multigenome saved by SaveBinary by mapping the file into memory.
+// The starred sequence and the SNP profile arrays are used in place; they are read-only and remain
+// valid until Close is called. The consistency of the multigenome is always checked; if verify is
+// set, so is the checksum, which reads the whole file once.
+func MapBinary(file_name string, verify bool) (*Multigenome, error) {
+	data, unmap, err := mmapFile(file_name)
+	if err != nil {
+		return nil, err
+	}
+	mg, err := decodeBinary(data, verify)
+	if err != nil {
+		unmap()
+		return nil, fmt.Errorf("%s: %w", file_name, err)
+	}
+	mg.unmap = unmap
+	return mg, nil
+}
+
+// Close releases the memory mapping of a multigenome loaded by MapBinary. The multigenome and
+// the slices returned by its accessors must not be used afterwards.
+// It does nothing for multigenomes which are not memory-mapped.
+func (mg *Multigenome) Close() error {
+	if mg.unmap == nil {
+		return nil
+	}
+	unmap := mg.unmap
+	mg.unmap = nil
+	return unmap()
+}
+
+// u32view returns b as a uint32 array without copying, or nil if the machine is not little-endian
+// or b is not aligned.
+func u32view(b []byte) []uint32 {
+	if len(b) < 4 {
+		return []uint32{}
+	}
+	one := uint16(1)
+	if *(*byte)(unsafe.Pointer(&one)) != 1 || uintptr(unsafe.Pointer(&b[0]))%4 != 0 {
+		return nil
+	}
+	return unsafe.Slice((*uint32)(unsafe.Pointer(&b[0])), len(b)/4)
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: memory-mapped loading module, reading files on systems without memory mapping.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"io/ioutil"
+)
+
+// mmapFile reads a file into memory on systems without mmap.
+func mmapFile(file_name string) ([]byte, func() error, error) {
+	data, err := ioutil.ReadFile(file_name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
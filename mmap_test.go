@@ -0,0 +1,68 @@
+//----------------------------------------------------------------------------------------
+// Test for memory-mapped multigenomes
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestMapBinary(t *testing.T) {
+	defer __(o_())
+
+	mg, err := Build("test_data/toy.fasta", "test_data/toy.vcf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin_file := filepath.Join(t.TempDir(), "genomestar.mgb")
+	if err = mg.SaveBinary(bin_file); err != nil {
+		t.Fatal(err)
+	}
+	for _, verify := range []bool{true, false} {
+		mapped, err := MapBinary(bin_file, verify)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(mapped.Seq(), mg.Seq()) {
+			t.Errorf("Fail mapping sequence: %s", mapped.Seq())
+		}
+		if err = sameProfile(mapped.Profile(), mg.Profile()); err != nil {
+			t.Errorf("Fail mapping profile: %v", err)
+		}
+		read := []byte("ACGTACGTATCGTTTA")
+		if r := mapped.BackwardDistance(read, 4, 19); r.Distance() != 0 {
+			t.Errorf("Fail aligning to mapped multigenome: %d", r.Distance())
+		}
+		if err = mapped.Close(); err != nil {
+			t.Errorf("Fail closing mapped multigenome: %v", err)
+		}
+	}
+
+	// Inconsistent arrays are detected without the checksum: sites out of order and a site which is
+	// not a "*".
+	data, _ := ioutil.ReadFile(bin_file)
+	site := bytes.Index(data, []byte("SITE")) + 16
+	for _, b := range []byte{data[site+4], 0} {
+		bad := append([]byte{}, data...)
+		bad[site] = b
+		ioutil.WriteFile(bin_file, bad, 0644)
+		if _, err = MapBinary(bin_file, false); err == nil {
+			t.Errorf("Fail detecting inconsistent site %d", b)
+		}
+	}
+
+	data[len(data)/2] ^= 1
+	ioutil.WriteFile(bin_file, data, 0644)
+	if _, err = MapBinary(bin_file, true); err == nil {
+		t.Errorf("Fail detecting corrupted file")
+	}
+	ioutil.WriteFile(bin_file, data[:len(data)-16], 0644)
+	if _, err = MapBinary(bin_file, false); err == nil {
+		t.Errorf("Fail detecting truncated file")
+	}
+}
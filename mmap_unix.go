@@ -0,0 +1,34 @@
+//go:build unix
+
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: memory-mapped loading module, memory mapping on Unix systems.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps a file read-only into memory.
+func mmapFile(file_name string) ([]byte, func() error, error) {
+	f, err := os.Open(file_name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if fi.Size() == 0 {
+		return nil, nil, ErrBadFormat
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, &os.PathError{Op: "mmap", Path: file_name, Err: err}
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
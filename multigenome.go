@@ -9,6 +9,7 @@ package multigenome
 
 import (
 	"bufio"
+	"io"
 	"io/ioutil"
 	"fmt"
 	"os"
@@ -20,20 +21,63 @@ import (
 
 type SNP struct{
 	profile []string
+	ref string // REF allele, if known
+	freq map[string]float64 // allele frequencies from the VCF INFO field (CAF or AF), if known
+	ids map[string]string // VCF IDs of the alternate alleles, if known
 }
 
+// LoadSNPLocation loads a SNP profile saved by SaveSNPLocation, and the allele length of sites whose
+// alleles all have the same length. It exits if the file cannot be read or its footer does not match.
 func LoadSNPLocation(file_name string )  (map[int] [][]byte, map[int]int) {
+	barr, is_equal, err := readSNPLocation(file_name)
+	if err != nil && err != errNoFooter {
+		fmt.Printf("%v\n",err)
+		os.Exit(1)
+	}
+	return barr, is_equal
+}
+
+// readSNPLocation loads a SNP profile. It returns errNoFooter with the profile if the file has no footer.
+func readSNPLocation(file_name string )  (map[int] [][]byte, map[int]int, error) {
+	f, err := os.Open(file_name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	barr, is_equal, err := decodeSNPLocation(f)
+	if err != nil && err != errNoFooter {
+		return nil, nil, fmt.Errorf("%s: %w", file_name, err)
+	}
+	return barr, is_equal, err
+}
+
+// ReadSNPLocation reads a SNP profile written by WriteSNPLocation, and the allele length of sites
+// whose alleles all have the same length. Data without a footer, as saved by older versions, is
+// read without being verified.
+func ReadSNPLocation(r io.Reader)  (map[int] [][]byte, map[int]int, error) {
+	barr, is_equal, err := decodeSNPLocation(r)
+	if err == errNoFooter {
+		err = nil
+	}
+	return barr, is_equal, err
+}
+
+// decodeSNPLocation reads a SNP profile as ReadSNPLocation, and returns errNoFooter with the
+// profile if the data has no footer.
+func decodeSNPLocation(r io.Reader)  (map[int] [][]byte, map[int]int, error) {
 	//location := make(map[int]SNP)
 	barr := make(map[int][][]byte)
 	is_equal := make(map[int]int)
 	
-	f,err := os.Open(file_name)
-    if err != nil{
-        fmt.Printf("%v\n",err)
-        os.Exit(1)
-    }
-	defer f.Close()
-    br := bufio.NewReader(f)
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, footer_err := splitFooter(data)
+	if footer_err == ErrTruncated {
+		return nil, nil, footer_err
+	}
+	br := bufio.NewReader(bytes.NewReader(data))
 	for{
 		line , err := br.ReadString('\n')
 		if err != nil {
@@ -65,46 +109,120 @@ func LoadSNPLocation(file_name string )  (map[int] [][]byte, map[int]int) {
 			is_equal[int(k)] = flag			
 		}
 	}
-	return barr, is_equal
+	return barr, is_equal, footer_err
 }
 
-func SaveSNPLocation(file_name string , SNP_arr map[int]SNP) {
-	file, err := os.Create(file_name)
-	if  err != nil {
-        return
-    }
-	defer file.Close()
-	for i, item := range SNP_arr {
+// SaveSNPLocation saves a SNP profile, one site per line with its alleles, followed by a footer.
+// The file is replaced atomically.
+func SaveSNPLocation(file_name string , SNP_arr map[int]SNP) error {
+	return writeFileAtomic(file_name, func(w *bufio.Writer) error {
+		return WriteSNPLocation(w, SNP_arr)
+	})
+}
+
+// WriteSNPLocation writes a SNP profile in the format of SaveSNPLocation.
+func WriteSNPLocation(w io.Writer, SNP_arr map[int]SNP) error {
+	// positions in increasing order, alleles in profile order
+	pos := make([]int, 0, len(SNP_arr))
+	for i := range SNP_arr {
+		pos = append(pos, i)
+	}
+	sort.Ints(pos)
+	fw := &footerWriter{w: bufio.NewWriter(w)}
+	if err := fw.header(); err != nil {
+		return err
+	}
+	for _, i := range pos {
 		str := ""
-		for _, v := range item.profile {
-			str = "\t" + v + str
+		for _, v := range SNP_arr[i].profile {
+			str = str + "\t" + v
 		}
 		key := strconv.Itoa(i)
-		_, err := file.WriteString(key + str + "\n"); 
-		if err != nil {
-            fmt.Println(err)
-            break
-        }
-	}	
+		if _, err := fw.WriteString(key + str + "\n"); err != nil {
+			return err
+		}
+	}
+	if err := fw.footer(); err != nil {
+		return err
+	}
+	return fw.w.Flush()
 }
 
-func SaveMulti(file_name string , multi []byte) {
-	file, err := os.Create(file_name)
-    if err != nil {
-        // handle the error here
-        return
-    }
-    defer file.Close()
-    file.Write(multi)
+// SaveMulti saves a starred multigenome, followed by a newline and a footer.
+// The file is replaced atomically.
+func SaveMulti(file_name string , multi []byte) error {
+	return writeFileAtomic(file_name, func(w *bufio.Writer) error {
+		return WriteMulti(w, multi)
+	})
+}
+
+// WriteMulti writes a starred multigenome in the format of SaveMulti.
+func WriteMulti(w io.Writer, multi []byte) error {
+	fw := &footerWriter{w: bufio.NewWriter(w)}
+	if err := fw.header(); err != nil {
+		return err
+	}
+	if _, err := fw.Write(multi); err != nil {
+		return err
+	}
+	if _, err := fw.WriteString("\n"); err != nil {
+		return err
+	}
+	if err := fw.footer(); err != nil {
+		return err
+	}
+	return fw.w.Flush()
 }
 
+// LoadMulti loads a starred multigenome saved by SaveMulti.
+// It returns nil if the file cannot be read or its footer does not match.
 func LoadMulti(file_name string) []byte {
-	bs, err := ioutil.ReadFile(file_name)
-    if err != nil {
-        return nil
-    }
-    str := string(bs)	
-	return ([]byte(str))
+	bs, err := readMulti(file_name)
+	if err != nil && err != errNoFooter {
+		return nil
+	}
+	return bs
+}
+
+// readMulti loads a starred multigenome. It returns errNoFooter with the sequence if the file has no footer.
+func readMulti(file_name string) ([]byte, error) {
+	f, err := os.Open(file_name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	bs, err := decodeMulti(f)
+	if err != nil && err != errNoFooter {
+		return nil, fmt.Errorf("%s: %w", file_name, err)
+	}
+	return bs, err
+}
+
+// ReadMulti reads a starred multigenome written by WriteMulti. Data without a footer, as saved by
+// older versions, is read without being verified.
+func ReadMulti(r io.Reader) ([]byte, error) {
+	bs, err := decodeMulti(r)
+	if err == errNoFooter {
+		err = nil
+	}
+	return bs, err
+}
+
+// decodeMulti reads a starred multigenome as ReadMulti, and returns errNoFooter with the sequence
+// if the data has no footer.
+func decodeMulti(r io.Reader) ([]byte, error) {
+	bs, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	bs, err = splitFooter(bs)
+	switch err {
+	case nil:
+		return bytes.TrimSuffix(bs, []byte("\n")), nil // newline before the footer
+	case errNoFooter:
+		return bs, err
+	}
+	return nil, err
 }
 
 // string * multi-genome
@@ -118,108 +236,155 @@ func buildMultigenome2(SNP_arr map[int]SNP, seq []byte) []byte {
 }
 
 func vcfRead(sequence_file string) map[int]SNP {
-	array := make(map[int]SNP)
-	f,err := os.Open(sequence_file)
-    if err != nil{
-        fmt.Printf("%v\n",err)
-        os.Exit(1)
-    }
-
-    defer f.Close()
-    br := bufio.NewReader(f)
-    //byte_array := bytes.Buffer{}
+	array, err := readVCF(sequence_file, nil)
+	if err != nil {
+		fmt.Printf("%v\n",err)
+		os.Exit(1)
+	}
+	return array
+}
 
-	for{
-		line , err := br.ReadString('\n')
-		if err != nil {
-			//fmt.Printf("%v\n",err)
-			break
-		}		
-		if line[0]==byte('#') {
-			//fmt.Printf("%s \n",line)
-		} else {
-			sline := string(line)
-			split := strings.Split(sline, "\t");
-			//fmt.Printf("%s %s %s\n", split[1], split[3], split[4])
-			pos, _ := strconv.ParseInt(split[1], 10, 64)
+// readVCF reads SNPs and INDELs from a VCF file into a SNP profile.
+// If contigs is not nil, positions are shifted by the offset of the record's contig (CHROM) in the
+// concatenated genome; otherwise they are taken as offsets into a single sequence.
+// Alleles "<DEL>" and "." are stored as ".", the deletion allele; the REF allele is kept in SNP.ref.
+func readVCF(sequence_file string, contigs []Contig) (map[int]SNP, error) {
+	array := make(map[int]SNP)
+	f, err := os.Open(sequence_file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) > 0 && line[0] != '#' {
+			split := strings.Split(line, "\t")
+			if len(split) < 5 {
+				return nil, fmt.Errorf("%s: bad VCF record %q", sequence_file, line)
+			}
+			pos, e := strconv.Atoi(split[1])
+			if e != nil || pos < 1 {
+				return nil, fmt.Errorf("%s: bad VCF position %q", sequence_file, split[1])
+			}
 			pos = pos - 1
-			if len(split[4])>1 {
-				alt := strings.Split(split[4], ",")
-				t := make([]string, len(alt)+1)
-				t[0] = split[3]				
-				for i:=0; i<len(alt); i++ {
-					if alt[i] == "<DEL>" {
-						t[i+1] = "."
-					} else {
-						t[i+1] = alt[i]
-					}					
-				}	
-				//sort.Strings(t)
-				//array[int(pos)] = SNP{t} // asign SNP at pos
-				tmp, ok := array[int(pos)]
-				if ok {
-					t = append(t[:0], t[1:]...)
-					tmp.profile = append(tmp.profile, t...)
-				} else {
-					tmp.profile = append(tmp.profile, t...)
+			if contigs != nil {
+				c, ok := findContig(contigs, split[0])
+				if !ok {
+					return nil, fmt.Errorf("%s: unknown contig %q", sequence_file, split[0])
+				}
+				if pos >= c.Len {
+					return nil, fmt.Errorf("%s: position %s is outside contig %s", sequence_file, split[1], c.Name)
 				}
-				sort.Strings(tmp.profile)
-				array[int(pos)] = tmp // append SNP at pos
-				//fmt.Printf("pos=%d %q \n", pos, alt)
-			} else {				
-				//array[int(pos)] = SNP{[]string{split[3], split[4]}} // asign SNP at pos
-				tmp, ok := array[int(pos)]
-				if ok {
-					if split[4] == "<DEL>" {
-						tmp.profile = append(tmp.profile, ".")
-					} else {
-						tmp.profile = append(tmp.profile, split[4])
-					}					
-				} else {
-					if split[4] == "<DEL>" {
-						tmp.profile = append(tmp.profile, []string{split[3], "."}...)
-					} else {
-						tmp.profile = append(tmp.profile, []string{split[3], split[4]}...)
+				pos += c.Offset
+			}
+			tmp, ok := array[pos]
+			if !ok {
+				tmp.profile = append(tmp.profile, split[3])
+				tmp.ref = split[3]
+			}
+			alts := strings.Split(split[4], ",")
+			for i, alt := range alts {
+				if alt == "<DEL>" {
+					alt = "."
+				}
+				alts[i] = alt
+				tmp.profile = append(tmp.profile, alt)
+				if split[2] != "." {
+					if tmp.ids == nil {
+						tmp.ids = make(map[string]string)
 					}
+					tmp.ids[alt] = split[2]
 				}
-				sort.Strings(tmp.profile)
-				array[int(pos)]= tmp // append SNP at pos
-				//fmt.Println(pos)
 			}
+			if len(split) > 7 {
+				if freq := infoFreqs(split[7], split[3], alts); freq != nil {
+					if tmp.freq == nil {
+						tmp.freq = make(map[string]float64)
+					}
+					for a, f := range freq {
+						tmp.freq[a] = f
+					}
+				}
+			}
+			sort.Strings(tmp.profile)
+			array[pos] = tmp // append SNP at pos
+		}
+		if err == io.EOF {
+			break
 		}
 	}
-    return array
+	return array, nil
+}
+
+// findContig finds a contig by VCF CHROM name. Names must match exactly, so that a VCF is never
+// applied to a reference whose contigs are named differently.
+func findContig(contigs []Contig, name string) (Contig, bool) {
+	for _, c := range contigs {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Contig{}, false
 }
 
 func fastaRead(sequence_file string) []byte {
-    f,err := os.Open(sequence_file)
-    if err != nil{
-        fmt.Printf("%v\n",err)
-        os.Exit(1)
-    }
-
-    defer f.Close()
-    br := bufio.NewReader(f)
-    byte_array := bytes.Buffer{}
-
-    //line , err := br.ReadString('\n')
-	_ , isPrefix, err := br.ReadLine()
-	if err != nil || isPrefix{
+	input, _, err := readFasta(sequence_file)
+	if err != nil {
 		fmt.Printf("%v\n",err)
 		os.Exit(1)
 	}
-    //fmt.Printf("%s",line)
-
-    for {
-        line , isPrefix, err := br.ReadLine()
-        if err != nil || isPrefix{
-            break
-        } else {
-            byte_array.Write([]byte(line))
-        }
-    }
-    //byte_array.Write([]byte("$"))
-    input := []byte(byte_array.String())
-    return input
+	return input
 }
 
+// readFasta reads all sequences of a FASTA file, concatenated in file order,
+// and returns the sequence dictionary entries (name, offset, length, MD5) of the contigs.
+func readFasta(sequence_file string) ([]byte, []Contig, error) {
+	f, err := os.Open(sequence_file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	byte_array := bytes.Buffer{}
+	var contigs []Contig
+
+	end_contig := func() {
+		if len(contigs) > 0 {
+			c := &contigs[len(contigs)-1]
+			c.Len = byte_array.Len() - c.Offset
+			c.MD5 = contigMD5(byte_array.Bytes()[c.Offset:])
+		}
+	}
+	for {
+		line, err := br.ReadBytes('\n')
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 && line[0] == '>' {
+			end_contig()
+			name := strings.Fields(string(line[1:]))
+			if len(name) == 0 {
+				return nil, nil, fmt.Errorf("%s: FASTA header without name", sequence_file)
+			}
+			contigs = append(contigs, Contig{Name: name[0], Offset: byte_array.Len()})
+		} else if len(line) > 0 {
+			if len(contigs) == 0 {
+				return nil, nil, fmt.Errorf("%s: sequence before first FASTA header", sequence_file)
+			}
+			byte_array.Write(line)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+	}
+	end_contig()
+	if len(contigs) == 0 {
+		return nil, nil, fmt.Errorf("%s: no FASTA sequence", sequence_file)
+	}
+	return byte_array.Bytes(), contigs, nil
+}
@@ -7,6 +7,7 @@ package multigenome
 
 import (
 	"fmt"
+	"os"
 	"runtime"
 	"strings"
 	"testing"
@@ -31,6 +32,9 @@ func __(name string) {
 func TestMultiGenomeBuild(t *testing.T) {
     defer __(o_())
 
+	if _, err := os.Stat("test_data/chr1.fasta"); err != nil {
+		t.Skip("test_data/chr1.fasta is not available")
+	}
 	sequence := fastaRead("test_data/chr1.fasta")
 	SNP_array := vcfRead("test_data/vcf_chr_1.vcf")
 	genome := buildMultigenome2(SNP_array, sequence)
@@ -0,0 +1,391 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: bit-parallel alignment module.
+// Myers' bit-vector edit distance (in Hyyrö's formulation) for the runs of columns of a gapped
+// alignment without variant sites, 64 columns per machine word, joined with the allele-aware DP at
+// the sites. Ungapped alignments have no edit operations outside the sites: their runs are single
+// diagonals of the band, which the DP already aligns in linear time.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"math/bits"
+)
+
+// myersRunMin is the smallest number of columns of a run between variant sites which is aligned by
+// the bit-parallel kernel; shorter runs are left to the DP, whose band is narrower.
+const myersRunMin = 32
+
+// myersRun is a run of columns s..e between variant sites of a gapped alignment, aligned by the
+// bit-parallel kernel from column s-1. The differences of its columns s+1..e are the nw words from
+// k of Workspace.pv and Workspace.mv.
+type myersRun struct {
+	s, e, k, nw int
+}
+
+// myersUnit returns whether the gapped alignments of the aligner have unit costs, which the
+// bit-parallel kernel needs: Ins and Del are 1, GapOpen is 0, EditScorer, no base qualities and no
+// clipping.
+func (a *Aligner) myersUnit(v *dpView) bool {
+	if a.cfg.Ins != 1 || a.cfg.Del != 1 || a.cfg.GapOpen != 0 || v.q != nil || a.cfg.clipped() {
+		return false
+	}
+	if a.cfg.Scorer != nil {
+		if _, ok := a.cfg.Scorer.(EditScorer); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// myersColumns returns the number of leading DP columns of a gapped alignment (from the free end)
+// which are aligned by the bit-parallel kernel: the columns before the first variant site, if the
+// alignment has unit costs.
+func (a *Aligner) myersColumns(v *dpView) int {
+	if !a.myersUnit(v) {
+		return 0
+	}
+	w := 0
+	for w < len(v.t) && v.win[w+1] < 0 {
+		w++
+	}
+	return w
+}
+
+// myers sets H[i][w] for each row i to the edit distance of the first i read bases to genome
+// columns 1..w, free at column 0 as in gappedDistance: H[0][j] is 0 and H[i][0] is i. H is stored
+// by rows of stride distances.
+//
+// The bits of the words of Pv and Mv are the columns of a row: a bit is set in Pv if the distance
+// increases by 1 from the previous column, in Mv if it decreases by 1. The rows are the text of
+// Myers' algorithm and the columns the pattern, which starts with distance i in row i.
+func (a *Aligner) myers(ws *Workspace, v *dpView, w int, H []int, stride int) {
+	m := len(v.s)
+	nw := (w + 63) / 64
+	// The words of the columns matching each base of the read, see Workspace.peq_slot.
+	slots := ws.peqSlots(v)
+	ws.peq = growUint64s(ws.peq, slots*nw)
+	for k := range ws.peq {
+		ws.peq[k] = 0
+	}
+	for j := 1; j <= w; j++ {
+		if s := ws.peq_slot[v.ref(j)]; s != 0 {
+			ws.peq[(s-1)*nw+(j-1)/64] |= 1 << uint((j-1)%64)
+		}
+	}
+	ws.pv, ws.mv = growUint64s(ws.pv, nw), growUint64s(ws.mv, nw)
+	Pv, Mv := ws.pv, ws.mv
+	for k := 0; k < nw; k++ {
+		Pv[k], Mv[k] = 0, 0
+	}
+	last := ^uint64(0) >> uint(64*nw-w) // the columns of the last word
+	for i := 1; i <= m; i++ {
+		s := ws.peq_slot[v.base(i)] - 1
+		eq := ws.peq[s*nw : (s+1)*nw]
+		hin := 1 // H[i][0] - H[i-1][0]
+		for k := 0; k < nw; k++ {
+			hin = myersBlock(&Pv[k], &Mv[k], eq[k], hin)
+		}
+		d := i
+		for k := 0; k < nw; k++ {
+			pk, mk := Pv[k], Mv[k]
+			if k == nw-1 {
+				pk, mk = pk&last, mk&last
+			}
+			d += bits.OnesCount64(pk) - bits.OnesCount64(mk)
+		}
+		H[i*stride+w] = d
+	}
+}
+
+// peqSlots numbers the distinct bases of the read of a view in Workspace.peq_slot, and returns
+// their number.
+func (ws *Workspace) peqSlots(v *dpView) int {
+	slots := 0
+	for c := range ws.peq_slot {
+		ws.peq_slot[c] = 0
+	}
+	for i := 1; i <= len(v.s); i++ {
+		if c := v.base(i); ws.peq_slot[c] == 0 {
+			slots++
+			ws.peq_slot[c] = slots
+		}
+	}
+	return slots
+}
+
+// myersRuns sets Workspace.runs to the runs of at least myersRunMin columns between the variant
+// sites of a gapped alignment with unit costs, after the leading w columns, and prepares their
+// words for myersRow. Alignments with allele priors are traced back as they are computed, which
+// would recompute the runs (see runPath), so they are left to the DP.
+func (a *Aligner) myersRuns(ws *Workspace, v *dpView, w int) {
+	ws.runs = ws.runs[:0]
+	if !a.myersUnit(v) || a.priors() {
+		return
+	}
+	n, words := len(v.t), 0
+	for j := w + 1; j <= n; j++ {
+		if v.win[j] >= 0 {
+			continue
+		}
+		e := j
+		for e < n && v.win[e+1] < 0 {
+			e++
+		}
+		if e-j+1 >= myersRunMin {
+			nw := (e - j + 63) / 64
+			ws.runs = append(ws.runs, myersRun{s: j, e: e, k: words, nw: nw})
+			words += nw
+		}
+		j = e
+	}
+	if len(ws.runs) == 0 {
+		return
+	}
+	slots := ws.peqSlots(v)
+	ws.peq = growUint64s(ws.peq, slots*words)
+	for k := range ws.peq {
+		ws.peq[k] = 0
+	}
+	for _, u := range ws.runs {
+		for j := u.s + 1; j <= u.e; j++ {
+			if s := ws.peq_slot[v.ref(j)]; s != 0 {
+				ws.peq[(s-1)*words+u.k+(j-u.s-1)/64] |= 1 << uint((j-u.s-1)%64)
+			}
+		}
+	}
+	ws.pv, ws.mv = growUint64s(ws.pv, words), growUint64s(ws.mv, words)
+	for k := 0; k < words; k++ {
+		ws.pv[k], ws.mv[k] = 0, 0
+	}
+	ws.words = words
+}
+
+// myersRow advances a run of the bit-parallel kernel from row i-1 to row i of a gapped alignment,
+// whose rows of H are Hp and Hi: it sets H[i][s] by the DP from column s-1, and H[i][e] from the
+// differences of the columns s+1..e, which the kernel updates given the vertical difference at
+// column s.
+//
+// Within the run, the distance changes by at most 1 from a column to the next, as at the start of
+// an alignment, but it may drop by more than 1 from a row to the next at column s, after a long
+// insertion allele of the site before it. The kernel does not handle these rows: they are computed
+// by the DP from the differences of row i-1, and encoded back.
+func (ws *Workspace) myersRow(v *dpView, u myersRun, i int, Hi, Hp []int) {
+	d := Hp[u.s-1]
+	if v.base(i) != v.ref(u.s) {
+		d++
+	}
+	if Hp[u.s]+1 < d {
+		d = Hp[u.s] + 1
+	}
+	if Hi[u.s-1]+1 < d {
+		d = Hi[u.s-1] + 1
+	}
+	Hi[u.s] = d
+	Pv, Mv := ws.pv[u.k:u.k+u.nw], ws.mv[u.k:u.k+u.nw]
+	if hin := d - Hp[u.s]; hin >= -1 {
+		s := ws.peq_slot[v.base(i)] - 1
+		eq := ws.peq[s*ws.words+u.k : s*ws.words+u.k+u.nw]
+		for k := range Pv {
+			hin = myersBlock(&Pv[k], &Mv[k], eq[k], hin)
+		}
+	} else {
+		up, left := Hp[u.s], d // H[i-1][j-1] and H[i][j-1]
+		for j := u.s + 1; j <= u.e; j++ {
+			k, bit := (j-u.s-1)/64, uint64(1)<<uint((j-u.s-1)%64)
+			diag := up
+			if Pv[k]&bit != 0 {
+				up++
+			} else if Mv[k]&bit != 0 {
+				up--
+			}
+			c := diag
+			if v.base(i) != v.ref(j) {
+				c++
+			}
+			if up+1 < c {
+				c = up + 1
+			}
+			if left+1 < c {
+				c = left + 1
+			}
+			Pv[k] &^= bit
+			Mv[k] &^= bit
+			if c > left {
+				Pv[k] |= bit
+			} else if c < left {
+				Mv[k] |= bit
+			}
+			left = c
+		}
+	}
+	last := ^uint64(0) >> uint(64*u.nw-(u.e-u.s)) // the columns of the last word
+	inc, dec := 0, 0
+	for k := range Pv {
+		pk, mk := Pv[k], Mv[k]
+		if k == u.nw-1 {
+			pk, mk = pk&last, mk&last
+		}
+		inc += bits.OnesCount64(pk)
+		dec += bits.OnesCount64(mk)
+	}
+	Hi[u.e] = d + inc - dec
+}
+
+// myersMin returns the smallest distance of the columns j..hi of a run of the bit-parallel kernel
+// in the row Hi it was last advanced to, from the differences of its columns. Only the columns of
+// the band are needed to abandon alignments, see gappedDistance.
+func (ws *Workspace) myersMin(u myersRun, Hi []int, j, hi int) int {
+	if hi > u.e {
+		hi = u.e
+	}
+	Pv, Mv := ws.pv[u.k:u.k+u.nw], ws.mv[u.k:u.k+u.nw]
+	d := Hi[u.s]
+	for k := 0; k < (j-u.s)/64; k++ {
+		d += bits.OnesCount64(Pv[k]) - bits.OnesCount64(Mv[k])
+	}
+	if r := uint((j - u.s) % 64); r > 0 {
+		k, low := (j-u.s)/64, uint64(1)<<r-1
+		d += bits.OnesCount64(Pv[k]&low) - bits.OnesCount64(Mv[k]&low)
+	}
+	min := d
+	for x := j + 1; x <= hi; x++ {
+		k, bit := (x-u.s-1)/64, uint64(1)<<uint((x-u.s-1)%64)
+		if Pv[k]&bit != 0 {
+			d++
+		} else if Mv[k]&bit != 0 {
+			if d--; d < min {
+				min = d
+			}
+		}
+	}
+	return min
+}
+
+// myersBlock advances a word of columns by one row, given the vertical difference hin of the
+// distance at the column before the word, and returns the vertical difference at its last column.
+func myersBlock(pv, mv *uint64, eq uint64, hin int) int {
+	Pv, Mv := *pv, *mv
+	Xv := eq | Mv
+	if hin < 0 {
+		eq |= 1
+	}
+	Xh := (((eq & Pv) + Pv) ^ Pv) | eq
+	Ph := Mv | ^(Xh | Pv)
+	Mh := Pv & Xh
+	hout := int(Ph>>63) - int(Mh>>63)
+	Ph <<= 1
+	Mh <<= 1
+	if hin < 0 {
+		Mh |= 1
+	} else if hin > 0 {
+		Ph |= 1
+	}
+	*pv = Mh | ^(Xv | Ph)
+	*mv = Ph & Xv
+	return hout
+}
+
+// runStart returns the first column of the run of the bit-parallel kernel which holds column j of
+// a gapped alignment, or 0 if column j is aligned by the DP.
+func (r *Result) runStart(j int) int {
+	if j <= r.kern {
+		return 1
+	}
+	for _, u := range r.ws.runs {
+		if u.s <= j && j <= u.e {
+			return u.s
+		}
+	}
+	return 0
+}
+
+// runBlock recomputes rows i0..i of the columns s..j of a run of the bit-parallel kernel by the
+// DP, with unit costs, into blk: it holds rows i0-1..i by rows of the j-s+2 columns from s-1, which
+// is taken from the DP matrix D of n+1 columns. Row i0-1 of the run is 0 if it is row 0, and out of
+// reach otherwise.
+func runBlock(v *dpView, blk, D []int, n, s, i0, i, j int) []int {
+	w := j - s + 2
+	blk = growInts(blk, (i-i0+2)*w)
+	for x := i0 - 1; x <= i; x++ {
+		row := blk[(x-i0+1)*w : (x-i0+2)*w]
+		row[0] = D[x*(n+1)+s-1]
+		for y := 1; y < w; y++ {
+			switch {
+			case x == 0:
+				row[y] = 0
+			case x == i0-1:
+				row[y] = 1000 * INF
+			default:
+				up := blk[(x-i0)*w : (x-i0+1)*w]
+				row[y] = up[y-1]
+				if v.base(x) != v.ref(s+y-1) {
+					row[y]++
+				}
+				if up[y]+1 < row[y] {
+					row[y] = up[y] + 1
+				}
+				if row[y-1]+1 < row[y] {
+					row[y] = row[y-1] + 1
+				}
+			}
+		}
+	}
+	return blk
+}
+
+// runPath traces back a gapped alignment from cell (i, j) through the columns s..j of a run of the
+// bit-parallel kernel, with the same preferences as gappedDistance: a match or mismatch, then an
+// insertion, then a deletion. It adds the columns to b, if it is not nil, and returns the cell
+// where it leaves the run. The alignment crosses the run between rows i-(j-s+1)-H[i][j] and i, as
+// each row more or less than the columns costs 1, so only these rows are recomputed.
+func (a *Aligner) runPath(b *alnBuilder, v *dpView, r Result, s, i, j int) (int, int) {
+	i0 := i - (j - s + 1) - r.ws.D[i*(r.N+1)+j]
+	if i0 < 1 {
+		i0 = 1
+	}
+	v.buf.blk = runBlock(v, v.buf.blk, r.ws.D, r.N, s, i0, i, j)
+	w := j - s + 2
+	H := func(x, y int) int {
+		return v.buf.blk[(x-i0+1)*w+y-s+1]
+	}
+	for i > 0 && j >= s {
+		d := H(i-1, j-1)
+		if v.base(i) != v.ref(j) {
+			d++
+		}
+		switch {
+		case d == H(i, j):
+			if b != nil {
+				b.match(i, j)
+			}
+			i, j = i-1, j-1
+		case H(i-1, j)+1 == H(i, j):
+			if b != nil {
+				b.ins(i)
+			}
+			i--
+		default:
+			if b != nil {
+				b.del(j)
+			}
+			j--
+		}
+	}
+	return i, j
+}
+
+// runCells returns a copy of the DP matrix of a gapped alignment with the cells of the runs after
+// the leading columns recomputed, see runBlock, for the tracebacks which need all of them.
+func runCells(v *dpView, r Result) []int {
+	D := append([]int(nil), r.ws.D[:(r.M+1)*(r.N+1)]...)
+	for _, u := range r.ws.runs {
+		v.buf.blk = runBlock(v, v.buf.blk, D, r.N, u.s, 1, r.M, u.e)
+		w := u.e - u.s + 2
+		for x := 1; x <= r.M; x++ {
+			copy(D[x*(r.N+1)+u.s:x*(r.N+1)+u.e+1], v.buf.blk[x*w+1:(x+1)*w])
+		}
+	}
+	return D
+}
@@ -0,0 +1,222 @@
+//----------------------------------------------------------------------------------------
+// Test for bit-parallel alignment
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// Unit-cost gapped alignments are the same with the bit-parallel kernel (EditScorer) and without
+// it (a matrix scorer of the same costs). Alignments over the threshold may be abandoned at
+// different rows.
+func TestAlignerMyers(t *testing.T) {
+	defer __(o_())
+
+	rnd := rand.New(rand.NewSource(5))
+	ones := onesScorer()
+	for _, l := range []int{10, 64, 65, 200} {
+		genome := make([]byte, 2*l+20)
+		for i := range genome {
+			genome[i] = "ACGT"[rnd.Intn(4)]
+		}
+		profile := type_snpprofile{l + 3: {{'A'}, {'C'}}, l + 10: {{'.'}, {'G'}, {'G', 'T'}}}
+		if l == 10 {
+			profile = type_snpprofile{}
+		}
+		for pos := range profile {
+			genome[pos] = '*'
+		}
+		p := NewProfile(profile)
+		for k := 0; k < 50; k++ {
+			var read []byte
+			for j, c := range genome {
+				if alleles, ok := profile[j]; ok {
+					if a := alleles[rnd.Intn(len(alleles))]; a[0] != '.' {
+						read = append(read, a...)
+					}
+					continue
+				}
+				switch x := rnd.Intn(30); {
+				case x == 0:
+					read = append(read, "ACGT"[rnd.Intn(4)])
+				case x == 1:
+					read = append(read, c, "ACGT"[rnd.Intn(4)])
+				case x == 2:
+				default:
+					read = append(read, c)
+				}
+			}
+			read = read[rnd.Intn(l):]
+			for _, thres := range []int{INF, 4} {
+				a := NewProfileAligner(p, Config{DistThres: thres, Ins: 1, Del: 1})
+				b := NewProfileAligner(p, Config{DistThres: thres, Ins: 1, Del: 1, Scorer: ones})
+				r1, r2 := a.Backward(read, genome, 0), b.Backward(read, genome, 0)
+				var s1, s2 map[int][]byte
+				if r1.Distance() <= thres {
+					s1, s2 = a.BackwardTraceBack(read, genome, r1, 0), b.BackwardTraceBack(read, genome, r2, 0)
+				}
+				if !sameDistance(r1, r2, thres) || fmt.Sprint(s1) != fmt.Sprint(s2) {
+					t.Errorf("Fail bit-parallel backward alignment (length %d, read %d): got %d %v, want %d %v", l, k, r1.Distance(), s1, r2.Distance(), s2)
+				}
+				if l > 10 && r1.kern == 0 && r1.OK {
+					t.Errorf("Fail using bit-parallel kernel (length %d, read %d)", l, k)
+				}
+				rev := read[:len(read)-rnd.Intn(l)]
+				r1, r2 = a.Forward(rev, genome, 0), b.Forward(rev, genome, 0)
+				s1, s2 = nil, nil
+				if r1.Distance() <= thres {
+					s1, s2 = a.ForwardTraceBack(rev, genome, r1, 0), b.ForwardTraceBack(rev, genome, r2, 0)
+				}
+				if !sameDistance(r1, r2, thres) || fmt.Sprint(s1) != fmt.Sprint(s2) {
+					t.Errorf("Fail bit-parallel forward alignment (length %d, read %d): got %d %v, want %d %v", l, k, r1.Distance(), s1, r2.Distance(), s2)
+				}
+			}
+		}
+	}
+}
+
+// Runs between variant sites are aligned by the bit-parallel kernel, also after long insertion
+// alleles, with the same distances, alleles, alignments and ties as by the DP.
+func TestAlignerMyersRuns(t *testing.T) {
+	defer __(o_())
+
+	rnd := rand.New(rand.NewSource(7))
+	ones := onesScorer()
+	genome := make([]byte, 400)
+	for i := range genome {
+		genome[i] = "ACGT"[rnd.Intn(4)]
+	}
+	profile := type_snpprofile{
+		40:  {{'A'}, {'C', 'G', 'T', 'A', 'C', 'G', 'T'}},
+		90:  {{'.'}, {'G'}},
+		170: {{'T'}, {'A', 'A', 'A', 'A', 'A'}, {'C'}},
+		205: {{'G'}, {'T'}},
+		300: {{'C'}, {'.'}, {'G', 'G', 'G', 'G', 'G', 'G', 'G', 'G', 'G'}},
+	}
+	for pos := range profile {
+		genome[pos] = '*'
+	}
+	p := NewProfile(profile)
+	for k := 0; k < 100; k++ {
+		var read []byte
+		for j, c := range genome {
+			if alleles, ok := profile[j]; ok {
+				if a := alleles[rnd.Intn(len(alleles))]; a[0] != '.' {
+					read = append(read, a...)
+				}
+				continue
+			}
+			switch x := rnd.Intn(40); {
+			case x == 0:
+				read = append(read, "ACGT"[rnd.Intn(4)])
+			case x == 1:
+				read = append(read, c, "ACGT"[rnd.Intn(4)])
+			case x == 2:
+			default:
+				read = append(read, c)
+			}
+		}
+		read = read[rnd.Intn(30):]
+		for _, thres := range []int{INF, 8} {
+			a := NewProfileAligner(p, Config{DistThres: thres, Ins: 1, Del: 1})
+			b := NewProfileAligner(p, Config{DistThres: thres, Ins: 1, Del: 1, Scorer: ones})
+			w := a.NewWorkspace()
+			r1, r2, r3 := a.Backward(read, genome, 0), b.Backward(read, genome, 0), w.Backward(read, genome, 0)
+			if !sameDistance(r1, r2, thres) || r1.Distance() != r3.Distance() {
+				t.Errorf("Fail bit-parallel alignment of runs (read %d): got %d %d, want %d", k, r1.Distance(), r3.Distance(), r2.Distance())
+				continue
+			}
+			if r1.OK && len(r1.ws.runs) == 0 {
+				t.Errorf("Fail using bit-parallel kernel for runs (read %d)", k)
+			}
+			if r1.Distance() > thres {
+				continue
+			}
+			s1, s2 := a.BackwardTraceBack(read, genome, r1, 0), b.BackwardTraceBack(read, genome, r2, 0)
+			if s3 := w.BackwardTraceBack(read, genome, r3, 0); fmt.Sprint(s1) != fmt.Sprint(s2) || fmt.Sprint(s3) != fmt.Sprint(s2) {
+				t.Errorf("Fail bit-parallel traceback of runs (read %d): got %v %v, want %v", k, s1, s3, s2)
+			}
+			if al1, al2 := a.BackwardAlignment(read, genome, r1, 0), b.BackwardAlignment(read, genome, r2, 0); al1 != al2 {
+				t.Errorf("Fail bit-parallel alignment of runs (read %d): got %v, want %v", k, al1, al2)
+			}
+			if t1, t2 := a.BackwardTies(read, genome, r1, 0), b.BackwardTies(read, genome, r2, 0); fmt.Sprint(t1) != fmt.Sprint(t2) {
+				t.Errorf("Fail bit-parallel ties of runs (read %d): got %v, want %v", k, t1, t2)
+			}
+		}
+	}
+}
+
+// sameDistance returns whether two alignments have the same distance, or both exceed thres.
+func sameDistance(r1, r2 Result, thres int) bool {
+	return r1.Distance() == r2.Distance() || r1.Distance() > thres && r2.Distance() > thres
+}
+
+// onesScorer returns a scorer with the costs of EditScorer, which the bit-parallel kernel does not
+// recognize, so that alignments with it are computed by the DP only.
+func onesScorer() *MatrixScorer {
+	var ones MatrixScorer
+	for x := range ones.M {
+		for y := range ones.M[x] {
+			ones.M[x][y] = 1
+		}
+	}
+	return &ones
+}
+
+func BenchmarkBackwardMyers(b *testing.B) {
+	benchGapped(b, nil, false, false)
+}
+
+func BenchmarkBackwardNoMyers(b *testing.B) {
+	benchGapped(b, onesScorer(), false, false)
+}
+
+// Windows with a variant site near their free end, whose other columns are a run between sites.
+func BenchmarkBackwardMyersRuns(b *testing.B) {
+	benchGapped(b, nil, false, true)
+}
+
+func BenchmarkBackwardNoMyersRuns(b *testing.B) {
+	benchGapped(b, onesScorer(), false, true)
+}
+
+// benchGapped aligns reads with unit-cost gaps and a scorer, with a workspace if ws is set, in
+// windows starting up to 10 bases before a variant site if site is set.
+func benchGapped(b *testing.B, sc Scorer, ws, site bool) {
+	genome, profile, _ := benchData()
+	p := NewProfile(profile)
+	rnd := rand.New(rand.NewSource(6))
+	var sites []int
+	for pos := range profile {
+		if pos >= 10 && pos+120 < len(genome) {
+			sites = append(sites, pos)
+		}
+	}
+	sort.Ints(sites)
+	pos := make([]int, 1000)
+	reads := make([][]byte, len(pos))
+	for k := range pos {
+		pos[k] = rnd.Intn(len(genome) - 120)
+		if site {
+			pos[k] = sites[rnd.Intn(len(sites))] - rnd.Intn(10)
+		}
+		reads[k] = benchRead(genome[pos[k]:pos[k]+110], pos[k], profile)[:100]
+	}
+	a := NewProfileAligner(p, Config{DistThres: INF, Ins: 1, Del: 1, Scorer: sc})
+	align := a.Backward
+	if ws {
+		align = a.NewWorkspace().Backward
+		b.ReportAllocs()
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := i % len(pos)
+		align(reads[k], genome[pos[k]:pos[k]+110], pos[k])
+	}
+}
@@ -0,0 +1,354 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: packed multigenome module.
+// Starred multigenomes with 2 bits per base, a list of runs of other characters (such as N) and a
+// rank/select bitvector marking the "*" sites.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"sort"
+)
+
+// PackedGenome is a starred multigenome stored with 2 bits per base.
+// Bases are upper-cased when packing, so soft-masking is not kept.
+type PackedGenome struct {
+	n     int
+	bases []byte           // 4 bases per byte, lowest bits first: A=0, C=1, G=2, T=3
+	exc   []packedRun      // runs of characters other than ACGT and "*", in increasing order
+	sites *sparseBitvector // "*" sites
+}
+
+// packedRun is a run of a character which is not stored in the 2-bit bases, e.g. a run of N.
+type packedRun struct {
+	start, len int
+	c          byte
+}
+
+var base_code = [256]byte{'C': 1, 'G': 2, 'T': 3, 'c': 1, 'g': 2, 't': 3}
+var code_base = [4]byte{'A', 'C', 'G', 'T'}
+
+// Pack packs a starred multigenome sequence.
+func Pack(seq []byte) *PackedGenome {
+	g := &PackedGenome{n: len(seq), bases: make([]byte, (len(seq)+3)/4)}
+	var sites []int
+	for i, c := range seq {
+		switch c {
+		case 'A', 'C', 'G', 'T', 'a', 'c', 'g', 't':
+			g.bases[i/4] |= base_code[c] << uint(2*(i%4))
+		case '*':
+			sites = append(sites, i)
+		default:
+			if k := len(g.exc) - 1; k >= 0 && g.exc[k].c == c && g.exc[k].start+g.exc[k].len == i {
+				g.exc[k].len++
+			} else {
+				g.exc = append(g.exc, packedRun{start: i, len: 1, c: c})
+			}
+		}
+	}
+	g.sites = newSparseBitvector(len(seq), sites)
+	return g
+}
+
+// Pack packs the starred sequence of a multigenome.
+func (mg *Multigenome) Pack() *PackedGenome {
+	return Pack(mg.seq)
+}
+
+// Len returns the length of the packed multigenome.
+func (g *PackedGenome) Len() int {
+	return g.n
+}
+
+// At returns the character at position i.
+func (g *PackedGenome) At(i int) byte {
+	if _, ok := g.sites.Rank(i); ok {
+		return '*'
+	}
+	if r := g.run(i); r < len(g.exc) && g.exc[r].start <= i {
+		return g.exc[r].c
+	}
+	return code_base[g.bases[i/4]>>uint(2*(i%4))&3]
+}
+
+// run returns the index of the first exception run which ends after position i.
+func (g *PackedGenome) run(i int) int {
+	return sort.Search(len(g.exc), func(r int) bool { return g.exc[r].start+g.exc[r].len > i })
+}
+
+// Extract appends the characters in [start, end) to dst and returns the extended slice.
+func (g *PackedGenome) Extract(dst []byte, start, end int) []byte {
+	for i := start; i < end; i++ {
+		dst = append(dst, code_base[g.bases[i/4]>>uint(2*(i%4))&3])
+	}
+	seg := dst[len(dst)-(end-start):]
+	for r := g.run(start); r < len(g.exc) && g.exc[r].start < end; r++ {
+		from, to := g.exc[r].start, g.exc[r].start+g.exc[r].len
+		if from < start {
+			from = start
+		}
+		if to > end {
+			to = end
+		}
+		for i := from; i < to; i++ {
+			seg[i-start] = g.exc[r].c
+		}
+	}
+	for k, _ := g.sites.Rank(start); k < g.sites.m; k++ {
+		p := g.sites.Select(k)
+		if p >= end {
+			break
+		}
+		seg[p-start] = '*'
+	}
+	return dst
+}
+
+// IsSite returns whether position i is a "*" site.
+func (g *PackedGenome) IsSite(i int) bool {
+	_, ok := g.sites.Rank(i)
+	return ok
+}
+
+// SiteIndex returns the number of "*" sites before position i, which is the index of the site
+// among the profile positions if i is a site, and whether i is a site.
+func (g *PackedGenome) SiteIndex(i int) (int, bool) {
+	return g.sites.Rank(i)
+}
+
+// Site returns the position of the k-th "*" site.
+func (g *PackedGenome) Site(k int) int {
+	return g.sites.Select(k)
+}
+
+// NumSites returns the number of "*" sites.
+func (g *PackedGenome) NumSites() int {
+	return g.sites.m
+}
+
+// Size returns the memory used by the packed multigenome in bytes.
+func (g *PackedGenome) Size() int {
+	return len(g.bases) + 24*len(g.exc) + g.sites.Size()
+}
+
+//-------------------------------------------------------------------------------------------------
+// Saving and loading packed multigenomes.
+// The file layout is that of the binary format (see format.go), with magic "MGPK" and sections:
+// 	"PKHD" length and number of "*" sites (uvarints)
+// 	"BASE" 2-bit bases
+// 	"RUNS" exception runs: count, then start, length (uvarints) and character
+// 	"EFLO" low bits of the "*" sites, uint64 array
+// 	"EFHI" high bits of the "*" sites, uint64 array
+// 	"END " as in the binary format
+// The rank and select directories of the sites are built when loading.
+//-------------------------------------------------------------------------------------------------
+
+const (
+	packedMagic   = "MGPK"
+	packedVersion = 1
+)
+
+// SaveBinary saves a packed multigenome. The file is replaced atomically.
+func (g *PackedGenome) SaveBinary(file_name string) error {
+	return writeFileAtomic(file_name, func(w *bufio.Writer) error {
+		return g.WriteBinary(w)
+	})
+}
+
+// WriteBinary writes a packed multigenome in the format of SaveBinary.
+func (g *PackedGenome) WriteBinary(out io.Writer) error {
+	bw := &binWriter{w: bufio.NewWriter(out), crc: crc32.New(crcTable)}
+	bw.header(packedMagic, packedVersion)
+	hd := appendUvarint(appendUvarint(nil, uint64(g.n)), uint64(g.sites.m))
+	bw.section("PKHD", len(hd))
+	bw.write(hd)
+	bw.pad()
+	bw.section("BASE", len(g.bases))
+	bw.write(g.bases)
+	bw.pad()
+	runs := appendUvarint(nil, uint64(len(g.exc)))
+	for _, r := range g.exc {
+		runs = append(appendUvarint(appendUvarint(runs, uint64(r.start)), uint64(r.len)), r.c)
+	}
+	bw.section("RUNS", len(runs))
+	bw.write(runs)
+	bw.pad()
+	bw.section("EFLO", 8*len(g.sites.low))
+	bw.uint64s(g.sites.low)
+	bw.pad()
+	bw.section("EFHI", 8*len(g.sites.high))
+	bw.uint64s(g.sites.high)
+	bw.pad()
+	return bw.end()
+}
+
+// LoadPacked loads a packed multigenome saved by SaveBinary, and verifies its checksum.
+func LoadPacked(file_name string) (*PackedGenome, error) {
+	data, err := ioutil.ReadFile(file_name)
+	if err != nil {
+		return nil, err
+	}
+	g, err := decodePacked(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file_name, err)
+	}
+	return g, nil
+}
+
+// ReadPacked reads a packed multigenome written by WriteBinary, and verifies its checksum.
+func ReadPacked(r io.Reader) (*PackedGenome, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodePacked(data)
+}
+
+// decodePacked decodes a packed multigenome and checks its consistency.
+func decodePacked(data []byte) (*PackedGenome, error) {
+	sec, err := readSections(data, packedMagic, packedVersion, true)
+	if err != nil {
+		return nil, err
+	}
+	br := &binReader{b: sec["PKHD"]}
+	n, m := int(br.uvarint()), int(br.uvarint())
+	if br.err != nil || n < 0 || m < 0 || m > n {
+		return nil, fmt.Errorf("inconsistent header of packed multigenome")
+	}
+	g := &PackedGenome{n: n, bases: sec["BASE"]}
+	if len(g.bases) != (n+3)/4 {
+		return nil, fmt.Errorf("inconsistent bases of packed multigenome")
+	}
+	br.b = sec["RUNS"]
+	for k := br.uvarint(); k > 0 && br.err == nil; k-- {
+		r := packedRun{start: int(br.uvarint()), len: int(br.uvarint())}
+		if c := br.bytes(1); c != nil {
+			r.c = c[0]
+		}
+		if end := len(g.exc) - 1; r.start < 0 || r.start > n || r.len <= 0 || r.len > n-r.start ||
+			(end >= 0 && r.start < g.exc[end].start+g.exc[end].len) {
+			return nil, fmt.Errorf("inconsistent exception runs of packed multigenome")
+		}
+		g.exc = append(g.exc, r)
+	}
+	if br.err != nil {
+		return nil, br.err
+	}
+
+	// The sites are checked to be increasing positions in the genome, with the directories built.
+	b := &sparseBitvector{n: n, m: m}
+	if m > 0 && n/m > 1 {
+		b.l = uint(bits.Len(uint(n/m)) - 1)
+	}
+	b.nhigh = m + n>>b.l + 1
+	b.low, b.high = le64s(sec["EFLO"]), le64s(sec["EFHI"])
+	if len(sec["EFLO"]) != 8*((m*int(b.l)+63)/64+1) || len(sec["EFHI"]) != 8*((b.nhigh+63)/64) ||
+		(b.nhigh%64 != 0 && b.high[len(b.high)-1]>>uint(b.nhigh%64) != 0) {
+		return nil, fmt.Errorf("inconsistent sites of packed multigenome")
+	}
+	ones := 0
+	for _, w := range b.high {
+		ones += bits.OnesCount64(w)
+	}
+	if ones != m {
+		return nil, fmt.Errorf("inconsistent sites of packed multigenome")
+	}
+	b.index()
+	for k, prev := 0, -1; k < m; k++ {
+		p := b.Select(k)
+		if p <= prev || p >= n {
+			return nil, fmt.Errorf("inconsistent sites of packed multigenome")
+		}
+		prev = p
+	}
+	g.sites = b
+	return g, nil
+}
+
+// le64s returns a little-endian uint64 array.
+func le64s(b []byte) []uint64 {
+	a := make([]uint64, len(b)/8)
+	for i := range a {
+		a[i] = binary.LittleEndian.Uint64(b[8*i:])
+	}
+	return a
+}
+
+//-------------------------------------------------------------------------------------------------
+// Alignment to packed multigenomes.
+// The segment [start, end) is unpacked once per call, the DP then runs as for starred sequences.
+// Segments which run past the end of a circular contig continue at its start. Workspaces unpack
+// segments into a buffer which they reuse, so that aligning to packed multigenomes does not allocate.
+//-------------------------------------------------------------------------------------------------
+
+// extract unpacks the segment [start, end), wrapped around the origin of a circular contig, into
+// dst, whose memory is reused.
+func (a *Aligner) extract(dst []byte, g *PackedGenome, start, end int) []byte {
+	c := a.wrapped(start, end-start)
+	if c.Len == 0 {
+		return g.Extract(dst[:0], start, end)
+	}
+	seg := g.Extract(dst[:0], start, c.Offset+c.Len)
+	for len(seg) < end-start {
+		l := end - start - len(seg)
+		if l > c.Len {
+			l = c.Len
+		}
+		seg = g.Extract(seg, c.Offset, c.Offset+l)
+	}
+	return seg
+}
+
+// BackwardPacked calculates the distance between s and the packed segment [start, end) in backward direction.
+func (a *Aligner) BackwardPacked(s []byte, g *PackedGenome, start, end int) Result {
+	return a.Backward(s, a.extract(nil, g, start, end), start)
+}
+
+// BackwardTraceBackPacked constructs the alignment found by BackwardPacked.
+func (a *Aligner) BackwardTraceBackPacked(s []byte, g *PackedGenome, start, end int, r Result) map[int][]byte {
+	return a.BackwardTraceBack(s, a.extract(nil, g, start, end), r, start)
+}
+
+// ForwardPacked calculates the distance between s and the packed segment [start, end) in forward direction.
+func (a *Aligner) ForwardPacked(s []byte, g *PackedGenome, start, end int) Result {
+	return a.Forward(s, a.extract(nil, g, start, end), start)
+}
+
+// ForwardTraceBackPacked constructs the alignment found by ForwardPacked.
+func (a *Aligner) ForwardTraceBackPacked(s []byte, g *PackedGenome, start, end int, r Result) map[int][]byte {
+	return a.ForwardTraceBack(s, a.extract(nil, g, start, end), r, start)
+}
+
+// BackwardPacked calculates the distance between s and the packed segment [start, end) in backward
+// direction, see Aligner.BackwardPacked.
+func (w *Workspace) BackwardPacked(s []byte, g *PackedGenome, start, end int) Result {
+	w.seg = w.a.extract(w.seg, g, start, end)
+	return w.Backward(s, w.seg, start)
+}
+
+// BackwardTraceBackPacked constructs the alignment found by BackwardPacked of the workspace.
+func (w *Workspace) BackwardTraceBackPacked(s []byte, g *PackedGenome, start, end int, r Result) map[int][]byte {
+	w.seg = w.a.extract(w.seg, g, start, end)
+	return w.BackwardTraceBack(s, w.seg, r, start)
+}
+
+// ForwardPacked calculates the distance between s and the packed segment [start, end) in forward
+// direction, see Aligner.ForwardPacked.
+func (w *Workspace) ForwardPacked(s []byte, g *PackedGenome, start, end int) Result {
+	w.seg = w.a.extract(w.seg, g, start, end)
+	return w.Forward(s, w.seg, start)
+}
+
+// ForwardTraceBackPacked constructs the alignment found by ForwardPacked of the workspace.
+func (w *Workspace) ForwardTraceBackPacked(s []byte, g *PackedGenome, start, end int, r Result) map[int][]byte {
+	w.seg = w.a.extract(w.seg, g, start, end)
+	return w.ForwardTraceBack(s, w.seg, r, start)
+}
@@ -0,0 +1,177 @@
+//----------------------------------------------------------------------------------------
+// Test for packed multigenomes
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestSparseBitvector(t *testing.T) {
+	defer __(o_())
+
+	rnd := rand.New(rand.NewSource(1))
+	for _, tc := range []struct{ n, step int }{{1, 1}, {100, 1}, {1000, 3}, {5000, 30}, {20000, 500}, {700, 1000}} {
+		var ones []int
+		is_one := make([]bool, tc.n)
+		for i := 0; i < tc.n; i++ {
+			if rnd.Intn(tc.step) == 0 {
+				ones = append(ones, i)
+				is_one[i] = true
+			}
+		}
+		b := newSparseBitvector(tc.n, ones)
+		for k, p := range ones {
+			if b.Select(k) != p {
+				t.Fatalf("Fail select (n %d, k %d): got %d, want %d", tc.n, k, b.Select(k), p)
+			}
+		}
+		rank := 0
+		for i := 0; i < tc.n; i++ {
+			r, ok := b.Rank(i)
+			if r != rank || ok != is_one[i] {
+				t.Fatalf("Fail rank (n %d, i %d): got %d %v, want %d %v", tc.n, i, r, ok, rank, is_one[i])
+			}
+			if is_one[i] {
+				rank++
+			}
+		}
+	}
+
+	// A cluster of ones followed by sparse ones gives blocks of zeros and of ones which span many
+	// bits, whose positions are listed in the select directories.
+	n := 1 << 24
+	var ones []int
+	for i := 0; i < 20000; i++ {
+		ones = append(ones, i)
+	}
+	for i := 1; i <= 300; i++ {
+		ones = append(ones, 20000+i*(n-20000)/301)
+	}
+	b := newSparseBitvector(n, ones)
+	if len(b.sel0.pos) == 0 || len(b.sel1.pos) == 0 {
+		t.Errorf("Fail listing sparse blocks: %d %d", len(b.sel0.pos), len(b.sel1.pos))
+	}
+	for k, p := range ones {
+		if b.Select(k) != p {
+			t.Fatalf("Fail select (k %d): got %d, want %d", k, b.Select(k), p)
+		}
+		if r, ok := b.Rank(p); r != k || !ok {
+			t.Fatalf("Fail rank (i %d): got %d %v, want %d", p, r, ok, k)
+		}
+		if r, ok := b.Rank(p + 1); p+1 < n && (r != k+1 || ok != (k+1 < len(ones) && ones[k+1] == p+1)) {
+			t.Fatalf("Fail rank (i %d): got %d %v, want %d", p+1, r, ok, k+1)
+		}
+	}
+}
+
+func TestPackedGenome(t *testing.T) {
+	defer __(o_())
+
+	seq := []byte("NNNNACG*TTacgtNNNRY*A*CCGGNN*T")
+	g := Pack(seq)
+	if got := string(g.Extract(nil, 0, len(seq))); got != string(bytes.ToUpper(seq)) {
+		t.Errorf("Fail unpacking: got %s", got)
+	}
+	for start := 0; start < len(seq); start++ {
+		for end := start; end <= len(seq); end++ {
+			if got := string(g.Extract(nil, start, end)); got != string(bytes.ToUpper(seq[start:end])) {
+				t.Fatalf("Fail extracting [%d, %d): got %s", start, end, got)
+			}
+		}
+		if g.At(start) != bytes.ToUpper(seq)[start] {
+			t.Errorf("Fail at %d: got %c", start, g.At(start))
+		}
+	}
+	for k, p := range []int{7, 19, 21, 28} {
+		if i, ok := g.SiteIndex(p); !ok || i != k || g.Site(k) != p {
+			t.Errorf("Fail site %d at %d: got %d %v %d", k, p, i, ok, g.Site(k))
+		}
+	}
+
+	// Saving and loading.
+	file_name := filepath.Join(t.TempDir(), "genomestar.mgp")
+	if err := g.SaveBinary(file_name); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadPacked(file_name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(loaded.Extract(nil, 0, len(seq))); got != string(bytes.ToUpper(seq)) || loaded.NumSites() != 4 {
+		t.Errorf("Fail loading packed multigenome: got %s", got)
+	}
+	data, _ := ioutil.ReadFile(file_name)
+	data[len(data)/2] ^= 1
+	if _, err = ReadPacked(bytes.NewReader(data)); err == nil {
+		t.Errorf("Fail detecting corrupted packed multigenome")
+	}
+	if _, err = LoadPacked("test_data/toy.fasta"); !errors.Is(err, ErrBadFormat) {
+		t.Errorf("Fail detecting file which is not a packed multigenome: %v", err)
+	}
+
+	// Memory use for a genome with a site every 30 bases on average.
+	rnd := rand.New(rand.NewSource(1))
+	big := make([]byte, 1<<22)
+	for i := range big {
+		big[i] = "ACGT"[rnd.Intn(4)]
+		if rnd.Intn(30) == 0 {
+			big[i] = '*'
+		}
+	}
+	g = Pack(big)
+	var buf bytes.Buffer
+	if err = g.WriteBinary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err = ReadPacked(&buf); err != nil || !bytes.Equal(loaded.Extract(nil, 0, len(big)), big) {
+		t.Errorf("Fail reading packed multigenome: %v", err)
+	}
+	fmt.Printf("%.2f bits per base\n", float64(8*g.Size())/float64(len(big)))
+	if 8*g.Size() > 5*len(big)/2 {
+		t.Errorf("Fail packing compactly: %d bytes for %d bases", g.Size(), len(big))
+	}
+}
+
+func TestAlignerPacked(t *testing.T) {
+	defer __(o_())
+
+	mg, err := Build("test_data/toy.fasta", "test_data/toy.vcf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, g := NewAligner(mg, DefaultConfig()), mg.Pack()
+	read := []byte("ACGTACGTATCGTTTA")
+	r := a.BackwardPacked(read, g, 4, 19)
+	if snp := a.BackwardTraceBackPacked(read, g, 4, 19, r); r.Distance() != 0 || string(snp[12]) != "AT" {
+		t.Errorf("Fail backward alignment to packed multigenome: %d %v", r.Distance(), snp)
+	}
+	r = a.ForwardPacked(read, g, 4, 19)
+	if snp := a.ForwardTraceBackPacked(read, g, 4, 19, r); r.Distance() != 0 || string(snp[12]) != "AT" {
+		t.Errorf("Fail forward alignment to packed multigenome: %d %v", r.Distance(), snp)
+	}
+
+	// Workspaces unpack segments into a buffer which they reuse.
+	w := a.NewWorkspace()
+	allocs := testing.AllocsPerRun(10, func() {
+		r := w.BackwardPacked(read, g, 4, 19)
+		if snp := w.BackwardTraceBackPacked(read, g, 4, 19, r); r.Distance() != 0 || string(snp[12]) != "AT" {
+			t.Errorf("Fail backward alignment to packed multigenome with workspace: %d %v", r.Distance(), snp)
+		}
+		r = w.ForwardPacked(read, g, 4, 19)
+		if snp := w.ForwardTraceBackPacked(read, g, 4, 19, r); r.Distance() != 0 || string(snp[12]) != "AT" {
+			t.Errorf("Fail forward alignment to packed multigenome with workspace: %d %v", r.Distance(), snp)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Fail reusing workspace for packed multigenome: %.1f allocations", allocs)
+	}
+}
@@ -0,0 +1,278 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: SNP profile module.
+// Variant alleles at the "*" sites of a multigenome.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Profile holds the alleles of the variant ("*") sites of a multigenome.
+// Alleles are byte strings, the deletion allele is ".".
+// Sites and alleles are stored in dense arrays, the same as in the binary format (see format.go), so
+// that the arrays of a memory-mapped file can be used in place.
+type Profile struct {
+	sites []uint32  // variant positions in increasing order
+	aidx  []uint32  // first allele of each site, with one more entry than sites
+	aoff  []uint32  // offset of each allele in blob, with one more entry than alleles
+	blob  []byte    // allele bytes
+	aref  []byte    // index of the reference allele of each site, little-endian uint16 (noRef if unknown)
+	afrq  []uint32  // frequency of each allele as float32 bits (negative if unknown), or nil (see freq.go)
+	prior []float32 // prior penalty of each allele, computed from afrq
+	ioff  []uint32  // offset of the VCF ID of each allele in iblb, or nil (see ids.go)
+	iblb  []byte    // allele ID bytes
+}
+
+// NewProfile creates a profile from alleles given by genome position, as returned by LoadSNPLocation.
+// Positions must be below 2^32, as in the binary format.
+func NewProfile(alleles map[int][][]byte) *Profile {
+	pos := make([]int, 0, len(alleles))
+	for k := range alleles {
+		pos = append(pos, k)
+	}
+	sort.Ints(pos)
+	p := &Profile{
+		sites: make([]uint32, len(pos)),
+		aidx:  make([]uint32, len(pos)+1),
+		aoff:  []uint32{0},
+		aref:  make([]byte, 2*len(pos)),
+	}
+	for k, v := range pos {
+		p.sites[k] = uint32(v)
+		for _, a := range alleles[v] {
+			p.blob = append(p.blob, a...)
+			p.aoff = append(p.aoff, uint32(len(p.blob)))
+		}
+		p.aidx[k+1] = p.aidx[k] + uint32(len(alleles[v]))
+		binary.LittleEndian.PutUint16(p.aref[2*k:], noRef)
+	}
+	return p
+}
+
+// newProfileSNP creates a profile from SNPs read from a VCF file, keeping their reference alleles.
+func newProfileSNP(SNP_arr map[int]SNP) *Profile {
+	alleles := make(map[int][][]byte, len(SNP_arr))
+	for pos, snp := range SNP_arr {
+		b := make([][]byte, len(snp.profile))
+		for i, v := range snp.profile {
+			b[i] = []byte(v)
+		}
+		alleles[pos] = b
+	}
+	p := NewProfile(alleles)
+	var afrq []uint32
+	var ids []string
+	for k, pos := range p.sites {
+		snp := SNP_arr[int(pos)]
+		if i := snp.refIndex(); i >= 0 {
+			binary.LittleEndian.PutUint16(p.aref[2*k:], uint16(i))
+		}
+		if snp.freq != nil && afrq == nil {
+			afrq = unknownFreqs(len(p.aoff) - 1)
+		}
+		for i, v := range snp.profile {
+			if f, ok := snp.freq[v]; ok {
+				afrq[int(p.aidx[k])+i] = math.Float32bits(float32(f))
+			}
+		}
+		if snp.ids != nil && ids == nil {
+			ids = make([]string, len(p.aoff)-1)
+		}
+		for i, v := range snp.profile {
+			if id, ok := snp.ids[v]; ok {
+				ids[int(p.aidx[k])+i] = id
+			}
+		}
+	}
+	if afrq != nil {
+		p.setFreqs(afrq)
+	}
+	if ids != nil {
+		p.ioff, p.iblb = packIDs(ids)
+	}
+	return p
+}
+
+// refIndex returns the index of the reference allele of a SNP in its profile, or -1 if it is not known.
+func (snp SNP) refIndex() int {
+	for i, v := range snp.profile {
+		if snp.ref != "" && v == snp.ref {
+			return i
+		}
+	}
+	return -1
+}
+
+// setRefs sets the reference alleles of sites, given by position as indices of their alleles.
+func (p *Profile) setRefs(refs map[int]int) error {
+	for pos, ref := range refs {
+		k := p.lowerBound(pos)
+		if k == len(p.sites) || int(p.sites[k]) != pos || ref < 0 || ref >= int(p.aidx[k+1]-p.aidx[k]) {
+			return fmt.Errorf("reference allele %d of position %d is not in the SNP profile", ref, pos)
+		}
+		binary.LittleEndian.PutUint16(p.aref[2*k:], uint16(ref))
+	}
+	return nil
+}
+
+// sameLen returns the common length of alleles, or 0 if they have different lengths or include
+// the deletion allele.
+func sameLen(alleles [][]byte) int {
+	if len(alleles) == 0 {
+		return 0
+	}
+	l := len(alleles[0])
+	for _, a := range alleles {
+		if len(a) != l || string(a) == "." {
+			return 0
+		}
+	}
+	return l
+}
+
+// slice returns the sites in [start, end), with positions relative to start.
+func (p *Profile) slice(start, end int) *Profile {
+	lo, hi := p.lowerBound(start), p.lowerBound(end)
+	a0, a1 := p.aidx[lo], p.aidx[hi]
+	q := &Profile{
+		sites: make([]uint32, hi-lo),
+		aidx:  make([]uint32, hi-lo+1),
+		aoff:  make([]uint32, a1-a0+1),
+		blob:  append([]byte{}, p.blob[p.aoff[a0]:p.aoff[a1]]...),
+		aref:  append([]byte{}, p.aref[2*lo:2*hi]...),
+	}
+	if p.ioff != nil {
+		q.iblb = append([]byte{}, p.iblb[p.ioff[a0]:p.ioff[a1]]...)
+		q.ioff = make([]uint32, a1-a0+1)
+		for a := a0; a <= a1; a++ {
+			q.ioff[a-a0] = p.ioff[a] - p.ioff[a0]
+		}
+	}
+	for k := lo; k < hi; k++ {
+		q.sites[k-lo] = p.sites[k] - uint32(start)
+	}
+	for k := lo; k <= hi; k++ {
+		q.aidx[k-lo] = p.aidx[k] - a0
+	}
+	for a := a0; a <= a1; a++ {
+		q.aoff[a-a0] = p.aoff[a] - p.aoff[a0]
+	}
+	if p.afrq != nil {
+		q.setFreqs(append([]uint32{}, p.afrq[a0:a1]...))
+	}
+	return q
+}
+
+//-------------------------------------------------------------------------------------------------
+// Accessors by genome position.
+//-------------------------------------------------------------------------------------------------
+
+// Len returns the number of variant sites.
+func (p *Profile) Len() int {
+	return len(p.sites)
+}
+
+// Alleles returns the alleles at a genome position, and whether the position is a variant site.
+func (p *Profile) Alleles(pos int) ([][]byte, bool) {
+	k, ok := p.find(pos)
+	if !ok {
+		return nil, false
+	}
+	a := make([][]byte, p.numAlleles(k))
+	for i := range a {
+		a[i] = p.allele(k, i)
+	}
+	return a, true
+}
+
+// SameLen returns the allele length at a site whose alleles all have the same length.
+func (p *Profile) SameLen(pos int) (int, bool) {
+	k, ok := p.find(pos)
+	if !ok {
+		return 0, false
+	}
+	l := p.siteSameLen(k)
+	return l, l != 0
+}
+
+// RefAllele returns the index of the reference allele at a site, or -1 if it is not known.
+func (p *Profile) RefAllele(pos int) int {
+	if k, ok := p.find(pos); ok {
+		return p.siteRef(k)
+	}
+	return -1
+}
+
+// Positions returns the variant sites in increasing order.
+func (p *Profile) Positions() []int {
+	pos := make([]int, len(p.sites))
+	for k, v := range p.sites {
+		pos[k] = int(v)
+	}
+	return pos
+}
+
+// allelesMap returns the alleles of all sites by position.
+func (p *Profile) allelesMap() map[int][][]byte {
+	alleles := make(map[int][][]byte, len(p.sites))
+	for _, v := range p.sites {
+		alleles[int(v)], _ = p.Alleles(int(v))
+	}
+	return alleles
+}
+
+//-------------------------------------------------------------------------------------------------
+// Accessors by site index, which do not allocate.
+//-------------------------------------------------------------------------------------------------
+
+// find returns the index of the site at a genome position.
+func (p *Profile) find(pos int) (int, bool) {
+	k := p.lowerBound(pos)
+	return k, k < len(p.sites) && int(p.sites[k]) == pos
+}
+
+// lowerBound returns the index of the first site at or after a genome position.
+func (p *Profile) lowerBound(pos int) int {
+	if pos <= 0 {
+		return 0
+	}
+	return sort.Search(len(p.sites), func(k int) bool { return int(p.sites[k]) >= pos })
+}
+
+// numAlleles returns the number of alleles of the k-th site.
+func (p *Profile) numAlleles(k int) int {
+	return int(p.aidx[k+1] - p.aidx[k])
+}
+
+// allele returns the i-th allele of the k-th site.
+func (p *Profile) allele(k, i int) []byte {
+	a := int(p.aidx[k]) + i
+	return p.blob[p.aoff[a]:p.aoff[a+1]:p.aoff[a+1]]
+}
+
+// siteSameLen returns the common allele length of the k-th site, see sameLen.
+func (p *Profile) siteSameLen(k int) int {
+	l := 0
+	for i := 0; i < p.numAlleles(k); i++ {
+		a := p.allele(k, i)
+		if len(a) == 0 || (i > 0 && len(a) != l) || (len(a) == 1 && a[0] == '.') {
+			return 0
+		}
+		l = len(a)
+	}
+	return l
+}
+
+// siteRef returns the index of the reference allele of the k-th site, or -1 if it is not known.
+func (p *Profile) siteRef(k int) int {
+	if ref := binary.LittleEndian.Uint16(p.aref[2*k:]); ref != noRef {
+		return int(ref)
+	}
+	return -1
+}
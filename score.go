@@ -0,0 +1,179 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: scoring module.
+// Costs of aligning read bases to genome bases and to variant alleles.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Scorer gives the costs used by the distance functions. Matching bases cost 0.
+type Scorer interface {
+	// Sub returns the cost of aligning read base r to a different genome base g.
+	Sub(r, g byte) int
+	// Allele returns the cost of aligning read bases s to a variant allele of the same length.
+	Allele(s, allele []byte) int
+}
+
+// scorer returns the scorer of an aligner.
+func (a *Aligner) scorer() Scorer {
+	var sc Scorer = EditScorer{}
+	if a.cfg.Scorer != nil {
+		sc = a.cfg.Scorer
+	}
+	if a.cfg.AlleleMismatches {
+		sc = alleleMismatchScorer{sc}
+	}
+	return sc
+}
+
+// qualCosts holds the mismatch weight of a read base of each Phred quality Q, the log-likelihood
+// ratio -10*log10((e/3) / (1-e)) of the base against a different genome base rather than the same
+// one, for the error probability e = 10^(-Q/10) spread over the three other bases; it is rounded,
+// and at least 1 so that mismatches are never free. Q40 weighs 45, Q20 25, Q5 8.
+var qualCosts = func() (c [94]int) {
+	c[0] = 1
+	for q := 1; q < len(c); q++ {
+		e := math.Pow(10, -float64(q)/10)
+		c[q] = int(math.Floor(-10*math.Log10(e/3/(1-e)) + 0.5))
+		if c[q] < 1 {
+			c[q] = 1
+		}
+	}
+	return
+}()
+
+// qualCost returns the mismatch weight of a Phred+33 quality character, see qualCosts.
+func qualCost(q byte) int {
+	switch {
+	case q < 33:
+		return qualCosts[0]
+	case int(q-33) >= len(qualCosts):
+		return qualCosts[len(qualCosts)-1]
+	}
+	return qualCosts[q-33]
+}
+
+// alleleMismatchScorer scores alleles by the mismatches of read bases, see Config.AlleleMismatches.
+type alleleMismatchScorer struct {
+	Scorer
+}
+
+func (sc alleleMismatchScorer) Allele(s, allele []byte) int {
+	d := 0
+	for i := range s {
+		if s[i] != allele[i] {
+			d += sc.Sub(s[i], allele[i])
+		}
+	}
+	return d
+}
+
+// EditScorer is the default scorer: mismatches cost 1, and read bases must match an allele exactly.
+type EditScorer struct{}
+
+func (EditScorer) Sub(r, g byte) int {
+	return 1
+}
+
+func (EditScorer) Allele(s, allele []byte) int {
+	return Cost(s, allele)
+}
+
+// TsTvScorer weights transitions (A<->G, C<->T) and transversions differently. Other mismatches,
+// such as those with N, cost Tv. Read bases must match an allele exactly.
+type TsTvScorer struct {
+	Ts, Tv int
+}
+
+func (sc TsTvScorer) Sub(r, g byte) int {
+	x, y := baseIndex(r), baseIndex(g)
+	if x < 4 && y < 4 && x^y == 2 { // A=0, C=1, G=2, T=3
+		return sc.Ts
+	}
+	return sc.Tv
+}
+
+func (sc TsTvScorer) Allele(s, allele []byte) int {
+	return Cost(s, allele)
+}
+
+// MatrixScorer gives substitution costs by a 5x5 matrix indexed by read and genome bases in the
+// order A, C, G, T, N; other characters are scored as N. Read bases must match an allele exactly.
+type MatrixScorer struct {
+	M [5][5]int
+}
+
+func (sc *MatrixScorer) Sub(r, g byte) int {
+	return sc.M[baseIndex(r)][baseIndex(g)]
+}
+
+func (sc *MatrixScorer) Allele(s, allele []byte) int {
+	return Cost(s, allele)
+}
+
+// baseIndex returns the index of a base in A, C, G, T, N, ignoring case.
+func baseIndex(b byte) int {
+	switch b {
+	case 'A', 'a':
+		return 0
+	case 'C', 'c':
+		return 1
+	case 'G', 'g':
+		return 2
+	case 'T', 't':
+		return 3
+	}
+	return 4
+}
+
+// LoadMatrixScorer loads a substitution matrix from a text file with five rows of five costs, for
+// read bases A, C, G, T, N against genome bases in the same order. Rows and columns may be labelled
+// with their bases, and lines starting with "#" are comments:
+//
+//	#  A C G T N
+//	A  0 2 1 2 1
+//	...
+func LoadMatrixScorer(file_name string) (*MatrixScorer, error) {
+	f, err := os.Open(file_name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := &MatrixScorer{}
+	row := 0
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || fields[0] == "A" && len(fields) == 5 {
+			continue
+		}
+		if len(fields) == 6 {
+			fields = fields[1:]
+		}
+		if len(fields) != 5 || row == 5 {
+			return nil, fmt.Errorf("%s: bad matrix line %q", file_name, s.Text())
+		}
+		for col, v := range fields {
+			if sc.M[row][col], err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("%s: bad cost %q", file_name, v)
+			}
+		}
+		row++
+	}
+	if err = s.Err(); err != nil {
+		return nil, err
+	}
+	if row != 5 {
+		return nil, fmt.Errorf("%s: matrix has %d rows, want 5", file_name, row)
+	}
+	return sc, nil
+}
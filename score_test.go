@@ -0,0 +1,138 @@
+//----------------------------------------------------------------------------------------
+// Test for scorers
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestScorers(t *testing.T) {
+	defer __(o_())
+
+	genome := []byte("ACGTACGT*CGT")
+	profile := NewProfile(type_snpprofile{8: {{'A'}, {'C'}}})
+	var test_cases = []struct {
+		scorer Scorer
+		read   string
+		d      int
+	}{
+		{nil, "ACGTACGTACGT", 0},
+		{nil, "ACGTGCGTACGT", 1},
+		{EditScorer{}, "ACGTGCGTACGG", 2},
+		{TsTvScorer{Ts: 1, Tv: 3}, "ACGTGCGTACGT", 1}, // A>G
+		{TsTvScorer{Ts: 1, Tv: 3}, "ACGTCCGTACGT", 3}, // A>C
+		{TsTvScorer{Ts: 1, Tv: 3}, "ACGTNCGTACGT", 3},
+		{TsTvScorer{Ts: 1, Tv: 3}, "ACGTACGTGCGT", INF},
+	}
+	for i, tc := range test_cases {
+		a := NewProfileAligner(profile, Config{DistThres: INF, Scorer: tc.scorer})
+		if r := a.Backward([]byte(tc.read), genome, 0); r.Distance() != tc.d {
+			t.Errorf("Fail scoring backward alignment (case %d): got %d, want %d", i, r.Distance(), tc.d)
+		}
+		if r := a.Forward([]byte(tc.read), genome, 0); r.Distance() != tc.d {
+			t.Errorf("Fail scoring forward alignment (case %d): got %d, want %d", i, r.Distance(), tc.d)
+		}
+	}
+
+	// Sequencing errors at variant sites
+	profile = NewProfile(type_snpprofile{8: {{'A'}, {'C'}}, 3: {{'T'}, {'T', 'T', 'A'}}})
+	genome = []byte("ACG*ACGT*CGT")
+	test_cases = []struct {
+		scorer Scorer
+		read   string
+		d      int
+	}{
+		{nil, "ACGTACGTGCGT", 1},
+		{TsTvScorer{Ts: 1, Tv: 3}, "ACGTACGTGCGT", 1},
+		{TsTvScorer{Ts: 2, Tv: 3}, "ACGTACGTTCGT", 2},
+		{nil, "ACGTGAACGTACGT", 1},
+		{nil, "ACGTGAACGTGCGT", 2},
+	}
+	for i, tc := range test_cases {
+		a := NewProfileAligner(profile, Config{DistThres: INF, Scorer: tc.scorer, AlleleMismatches: true})
+		if r := a.Backward([]byte(tc.read), genome, 0); r.Distance() != tc.d {
+			t.Errorf("Fail scoring allele mismatches backward (case %d): got %d, want %d", i, r.Distance(), tc.d)
+		}
+		if r := a.Forward([]byte(tc.read), genome, 0); r.Distance() != tc.d {
+			t.Errorf("Fail scoring allele mismatches forward (case %d): got %d, want %d", i, r.Distance(), tc.d)
+		}
+	}
+	a := NewProfileAligner(profile, DefaultConfig())
+	if r := a.Backward([]byte("ACGTACGTGCGT"), genome, 0); r.Distance() != INF {
+		t.Errorf("Fail requiring exact alleles: got %d", r.Distance())
+	}
+
+	dir := t.TempDir()
+	matrix_file := filepath.Join(dir, "matrix.txt")
+	ioutil.WriteFile(matrix_file, []byte("# read \\ genome\n#  A C G T N\nA  0 4 2 4 1\nC  4 0 4 2 1\nG  2 4 0 4 1\nT  4 2 4 0 1\nN  1 1 1 1 1\n"), 0644)
+	sc, err := LoadMatrixScorer(matrix_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Sub('A', 'C') != 4 || sc.Sub('g', 'a') != 2 || sc.Sub('N', 'T') != 1 || sc.Sub('-', 'A') != 1 {
+		t.Errorf("Fail loading matrix: %v", sc.M)
+	}
+	a = NewProfileAligner(profile, Config{DistThres: INF, Scorer: sc})
+	if r := a.Backward([]byte("ACGTGCGTACGN"), []byte("ACGTACGT*CGT"), 0); r.Distance() != 3 {
+		t.Errorf("Fail scoring with matrix: got %d, want 3", r.Distance())
+	}
+	ioutil.WriteFile(matrix_file, []byte("0 1 1 1 1\n1 0 1 1\n"), 0644)
+	if _, err = LoadMatrixScorer(matrix_file); err == nil {
+		t.Errorf("Fail reporting bad matrix")
+	}
+}
+
+func TestQualities(t *testing.T) {
+	defer __(o_())
+
+	genome := []byte("ACGTACGT*CGT")
+	profile := NewProfile(type_snpprofile{8: {{'A'}, {'C'}}})
+	var test_cases = []struct {
+		cfg        Config
+		read, qual string
+		d          int
+	}{
+		{DefaultConfig(), "ACGTACGTACGT", "IIIIIIIIIIII", 0},
+		{DefaultConfig(), "ACGTGCGTACGT", "IIIIIIIIIIII", 45},
+		{DefaultConfig(), "ACGTGCGTACGT", "IIII5IIIIIII", 25},
+		{DefaultConfig(), "ACGTGCGTACGT", "IIII&IIIIIII", 8},
+		{DefaultConfig(), "ACGTGCGTACGT", "IIII!IIIIIII", 1},
+		{DefaultConfig(), "ACGTACGTGCGT", "IIIIIIII&III", 8},
+		{DefaultConfig(), "ACGTACGTGCGT", "IIIIIIIIIIII", 45},
+		{Config{DistThres: INF, AlleleMismatches: true}, "ACGTACGTGCGT", "IIIIIIII&III", 8},
+		{Config{DistThres: INF, AlleleMismatches: true}, "ACGTACGTGCGT", "IIIIIIIIIIII", 45},
+		{Config{DistThres: INF, Scorer: TsTvScorer{Ts: 1, Tv: 2}}, "ACGTCCGTACGT", "IIII&IIIIIII", 16},
+		{Config{DistThres: INF, Ins: 30, Del: 30}, "ACGTGCGTACGT", "IIII&IIIIIII", 8},
+		{Config{DistThres: INF, Ins: 30, Del: 30}, "ACGTACGTGCGT", "IIIIIIII&III", 8},
+		{Config{DistThres: INF, Ins: 30, Del: 30, AlleleMismatches: true}, "ACGTACGTGCGT", "IIIIIIII&III", 8},
+	}
+	for i, tc := range test_cases {
+		a := NewProfileAligner(profile, tc.cfg)
+		read, qual := []byte(tc.read), []byte(tc.qual)
+		r, err := a.BackwardQual(read, qual, genome, 0)
+		if err != nil || r.Distance() != tc.d {
+			t.Errorf("Fail backward alignment with qualities (case %d): got %d, want %d", i, r.Distance(), tc.d)
+		}
+		if r.Distance() < INF {
+			if snp := a.BackwardTraceBack(read, genome, r, 0); string(snp[8]) != tc.read[8:9] {
+				t.Errorf("Fail backward traceback with qualities (case %d): %v", i, snp)
+			}
+		}
+		r, err = a.ForwardQual(read, qual, genome, 0)
+		if err != nil || r.Distance() != tc.d {
+			t.Errorf("Fail forward alignment with qualities (case %d): got %d, want %d", i, r.Distance(), tc.d)
+		}
+	}
+	a := NewProfileAligner(profile, DefaultConfig())
+	if _, err := a.BackwardQual([]byte("ACGTACGTACGT"), []byte("IIII"), genome, 0); err == nil {
+		t.Errorf("Fail reporting qualities of the wrong length")
+	}
+	if _, err := a.NewWorkspace().ForwardQual([]byte("ACGTACGTACGT"), []byte("IIII"), genome, 0); err == nil {
+		t.Errorf("Fail reporting qualities of the wrong length")
+	}
+}
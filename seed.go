@@ -0,0 +1,150 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: seed extension module.
+// Alignment of a whole read from a seed: the read before the seed is aligned backward and the read
+// from the seed forward, and both alignments are merged.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"fmt"
+)
+
+// Extension is the alignment of a read extended from a seed in both directions.
+type Extension struct {
+	Dist      int            // total distance of the read before the seed and of the read from the seed
+	Left      Result         // backward alignment of the read before the seed
+	Right     Result         // forward alignment of the read from the seed
+	Alignment                // alignment of the whole read to the linear reference
+	Calls     map[int][]byte // read bases aligned to the variant sites
+	OK        bool           // false if the distance exceeds Config.DistThres
+}
+
+// ExtendSeed aligns a read whose bases read_pos..read_pos+seed_len-1 are a seed found at position
+// genome_pos of a starred multigenome (the whole multigenome, with the positions of the profile).
+// The read before the seed is aligned backward to the genome ending at genome_pos, and the read from
+// the seed forward to the genome starting at genome_pos, with the distance threshold left by the
+// backward alignment. The genome parts are long enough for the deletions the aligner can find.
+// Local aligners extend seeds as semi-global ones, since the read is not clipped at the seed.
+// Extensions from a seed in a circular contig continue across its origin.
+func (a *Aligner) ExtendSeed(read, genome []byte, read_pos, genome_pos, seed_len int) (Extension, error) {
+	if read_pos < 0 || seed_len < 0 || read_pos+seed_len > len(read) || genome_pos < 0 || genome_pos+seed_len > len(genome) {
+		return Extension{}, fmt.Errorf("seed of %d bases at %d of the read and %d of the genome is outside them", seed_len, read_pos, genome_pos)
+	}
+	e := Extension{Alignment: Alignment{Pos: -1, Cigar: "*"}}
+	left, right := read[:read_pos], read[read_pos:]
+	if a.cfg.Mode == ModeLocal {
+		c := *a
+		c.cfg.Mode = ModeSemiGlobal
+		a = &c
+	}
+
+	lt, start := a.extension(genome, genome_pos, genome_pos-a.extensionLen(len(left), genome_pos, -1), genome_pos)
+	e.Left = a.Backward(left, lt, start)
+	e.Dist = e.Left.Distance()
+	if !e.Left.OK || e.Dist > a.cfg.DistThres || e.Dist >= INF {
+		return e, nil
+	}
+
+	rt, _ := a.extension(genome, genome_pos, genome_pos, genome_pos+a.extensionLen(len(right), genome_pos, 1))
+	b := *a
+	if b.cfg.DistThres < INF {
+		b.cfg.DistThres -= e.Dist
+	}
+	e.Right = b.Forward(right, rt, genome_pos)
+	e.Dist += e.Right.Distance()
+	if !e.Right.OK || e.Dist > a.cfg.DistThres || e.Right.Distance() >= INF {
+		return e, nil
+	}
+
+	lv, rv := dpView{s: left, t: lt, pos: start}, dpView{s: right, t: rt, pos: genome_pos, fwd: true}
+	lcols, _ := a.alignCols(lv, e.Left)
+	rcols, _ := b.alignCols(rv, e.Right)
+	e.Alignment = newAlignment(append(lcols, rcols...))
+	e.Calls = a.traceBack(lv, e.Left)
+	for pos, call := range b.traceBack(rv, e.Right) {
+		e.Calls[pos] = call
+	}
+	e.OK = true
+	return e, nil
+}
+
+// extension returns the genome part [start, end) of an extension from genome_pos, and the position
+// of its first base. It is cut at the ends of the genome, or wrapped around the origin of a circular
+// contig containing genome_pos, see wrapRanges.
+func (a *Aligner) extension(genome []byte, genome_pos, start, end int) ([]byte, int) {
+	rg := a.wrapRanges(genome_pos, start, end)
+	if len(rg) == 2 {
+		return append(append([]byte{}, genome[rg[0][0]:rg[0][1]]...), genome[rg[1][0]:rg[1][1]]...), rg[0][0]
+	}
+	start, end = rg[0][0], rg[0][1]
+	if start < 0 {
+		start = 0
+	}
+	if end > len(genome) {
+		end = len(genome)
+	}
+	return genome[start:end], start
+}
+
+// wrapRanges returns the genome ranges of the positions in [lo, hi), which contains genome_pos or
+// ends there: the range itself, or its parts before and after the origin of a circular contig
+// containing genome_pos, in genome order along the extension. A range longer than the contig is
+// cut to its length on the side away from genome_pos.
+func (a *Aligner) wrapRanges(genome_pos, lo, hi int) [][2]int {
+	for _, c := range a.circular {
+		if genome_pos < c.Offset || genome_pos >= c.Offset+c.Len {
+			continue
+		}
+		if hi-lo > c.Len {
+			if lo < genome_pos {
+				lo = hi - c.Len
+			} else {
+				hi = lo + c.Len
+			}
+		}
+		switch end := c.Offset + c.Len; {
+		case lo < c.Offset:
+			return [][2]int{{lo + c.Len, end}, {c.Offset, hi}}
+		case hi > end:
+			return [][2]int{{lo, end}, {c.Offset, hi - c.Len}}
+		}
+		return [][2]int{{lo, hi}}
+	}
+	return [][2]int{{lo, hi}}
+}
+
+// extensionLen returns the number of genome bases from genome_pos, in direction dir (1 forward, -1
+// backward), needed to align l read bases: l, and one more for each deletion outside variant sites
+// allowed by the distance threshold and for each site with a deletion allele, also across the
+// origin of a circular contig.
+func (a *Aligner) extensionLen(l, genome_pos, dir int) int {
+	allow := 0
+	if a.cfg.Del > 0 {
+		allow = l
+		if a.cfg.DistThres < INF && a.cfg.DistThres/a.cfg.Del < allow {
+			allow = a.cfg.DistThres / a.cfg.Del
+		}
+	}
+	p := a.profile
+	n, dels := l+allow, 0
+	for {
+		lo, hi := genome_pos, genome_pos+n
+		if dir < 0 {
+			lo, hi = genome_pos-n, genome_pos
+		}
+		dels = 0
+		for _, rg := range a.wrapRanges(genome_pos, lo, hi) {
+			for k := p.lowerBound(rg[0]); k < len(p.sites) && int(p.sites[k]) < rg[1]; k++ {
+				if p.siteSameLen(k) == 0 && p.alleleIndex(k, []byte{'.'}) >= 0 {
+					dels++
+				}
+			}
+		}
+		if l+allow+dels <= n {
+			return n
+		}
+		n = l + allow + dels
+	}
+}
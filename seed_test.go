@@ -0,0 +1,110 @@
+//----------------------------------------------------------------------------------------
+// Test for seed extension
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestExtendSeed(t *testing.T) {
+	defer __(o_())
+
+	p := newProfileSNP(map[int]SNP{3: {profile: []string{"A", "C"}, ref: "A"}, 7: {profile: []string{".", "A", "AT"}, ref: "A"}})
+	genome := []byte("ACC*CGT*CGTA") // reference ACCACGTACGTA
+	var test_cases = []struct {
+		ins, del           int
+		read               string
+		read_pos, seed_pos int
+		d                  int
+		al                 Alignment
+		calls              string
+	}{
+		{0, 0, "ACCACGTACGTA", 4, 4, 0, Alignment{0, "12M", "12", 0}, "map[3:A 7:A]"},
+		{0, 0, "ACCCCGTATCGTA", 0, 0, 0, Alignment{0, "8M1I4M", "3A8", 2}, "map[3:C 7:AT]"},
+		{0, 0, "ACCCCGTATCGTA", 9, 8, 0, Alignment{0, "8M1I4M", "3A8", 2}, "map[3:C 7:AT]"},
+		{0, 0, "ACCCCGTATCGTA", 13, 12, 0, Alignment{0, "8M1I4M", "3A8", 2}, "map[3:C 7:AT]"},
+		{0, 0, "CACGTCGTA", 5, 8, 0, Alignment{2, "5M1D4M", "5^A4", 1}, "map[3:A 7:]"},
+		{0, 0, "CAGGTACG", 2, 4, 1, Alignment{2, "8M", "2C5", 1}, "map[3:A 7:A]"},
+		{1, 1, "CACTGTACG", 4, 5, 1, Alignment{2, "3M1I5M", "8", 1}, "map[3:A 7:A]"},
+		{1, 1, "CACTGTACG", 2, 4, 1, Alignment{2, "3M1I5M", "8", 1}, "map[3:A 7:A]"},
+	}
+	for i, tc := range test_cases {
+		a := NewProfileAligner(p, Config{DistThres: 2, Ins: tc.ins, Del: tc.del})
+		e, err := a.ExtendSeed([]byte(tc.read), genome, tc.read_pos, tc.seed_pos, 0)
+		calls := map[int]string{}
+		for pos, call := range e.Calls {
+			calls[pos] = string(call)
+		}
+		if err != nil || !e.OK || e.Dist != tc.d || e.Alignment != tc.al || fmt.Sprint(calls) != tc.calls {
+			t.Errorf("Fail extending seed (case %d): got %d %v %v %v, want %d %v %s", i, e.Dist, e.Alignment, calls, err, tc.d, tc.al, tc.calls)
+		}
+	}
+	a := NewProfileAligner(p, Config{DistThres: 0})
+	if e, err := a.ExtendSeed([]byte("AGCACGTACGTA"), genome, 6, 6, 2); err != nil || e.OK || e.Dist <= 0 {
+		t.Errorf("Fail extending seed over the distance threshold: got %d %v %v", e.Dist, e.OK, err)
+	}
+	if _, err := a.ExtendSeed([]byte("ACGT"), genome, 2, 11, 2); err == nil {
+		t.Errorf("Fail reporting a seed outside the genome")
+	}
+
+	// Extensions of reads from a seed are consistent with the read and the reference, and their
+	// distance is that of both directions.
+	rnd := rand.New(rand.NewSource(9))
+	ref := make([]byte, 150)
+	for i := range ref {
+		ref[i] = "ACGT"[rnd.Intn(4)]
+	}
+	genome = append([]byte{}, ref...)
+	SNP_arr := map[int]SNP{}
+	for _, pos := range []int{20, 31, 70, 72, 90, 120} {
+		alt := string("ACGT"[rnd.Intn(4)])
+		if pos%2 == 0 {
+			alt = string(ref[pos]) + alt
+		}
+		SNP_arr[pos] = SNP{profile: []string{string(ref[pos]), alt, "."}, ref: string(ref[pos])}
+		genome[pos] = '*'
+	}
+	p = newProfileSNP(SNP_arr)
+	for _, cfg := range []Config{{DistThres: INF}, {DistThres: INF, Ins: 1, Del: 1}, {DistThres: 6, Ins: 2, Del: 2, GapOpen: 1}} {
+		a := NewProfileAligner(p, cfg)
+		for k := 0; k < 100; k++ {
+			start, seed := rnd.Intn(60), 10+rnd.Intn(60)
+			var read []byte
+			read_pos, seed_pos := 0, 0
+			for j := start; j < start+80; j++ {
+				if j == start+seed {
+					read_pos, seed_pos = len(read), j
+				}
+				if snp, ok := SNP_arr[j]; ok {
+					if allele := snp.profile[rnd.Intn(3)]; allele != "." {
+						read = append(read, allele...)
+					}
+				} else if j >= start+seed && j < start+seed+5 {
+					read = append(read, ref[j])
+				} else if rnd.Intn(30) == 0 {
+					read = append(read, "ACGT"[rnd.Intn(4)])
+				} else if rnd.Intn(40) != 0 || cfg.Ins == 0 {
+					read = append(read, ref[j])
+				}
+			}
+			if _, ok := SNP_arr[seed_pos]; ok {
+				continue
+			}
+			e, err := a.ExtendSeed(read, genome, read_pos, seed_pos, 5)
+			if err != nil || e.Dist != e.Left.Distance()+e.Right.Distance() {
+				t.Errorf("Fail extending seed (config %v, read %d %s): %d %d %d %v", cfg, k, read, e.Dist, e.Left.Distance(), e.Right.Distance(), err)
+			}
+			if err := checkAlignment(read, ref, e.Alignment); e.OK && err != nil {
+				t.Errorf("Fail extending seed (config %v, read %d %s): %v %v", cfg, k, read, e.Alignment, err)
+			}
+			if !e.OK && cfg.DistThres == INF {
+				t.Errorf("Fail extending seed without distance threshold (config %v, read %d %s)", cfg, k, read)
+			}
+		}
+	}
+}
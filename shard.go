@@ -0,0 +1,170 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: sharded storage module.
+// Multigenomes stored as a directory with one binary shard per contig and a manifest, so that
+// contigs can be loaded when they are first used and released when they are no longer needed.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The manifest is a sequence dictionary (see SaveSeqDict) listing the contigs in genome order with
+// the metadata of the multigenome; the k-th contig is stored in the binary file shardFile(k), whose
+// profile checksum the manifest records.
+const manifestFile = "manifest.dict"
+
+func shardFile(k int) string {
+	return fmt.Sprintf("contig%d.mgb", k)
+}
+
+// SaveSharded saves a multigenome in a directory, with one binary shard per contig and a manifest.
+// Positions in a shard are relative to the start of its contig. The manifest of a previous save is
+// removed first and the new one is written last, so an interrupted save leaves a directory which
+// cannot be opened; shards of different saves are detected by their checksums.
+func (mg *Multigenome) SaveSharded(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, manifestFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	sums := make(map[int]string, len(mg.contigs))
+	for k, c := range mg.contigs {
+		shard := mg.contigGenome(c)
+		if err := shard.SaveBinary(filepath.Join(dir, shardFile(k))); err != nil {
+			return err
+		}
+		sums[k] = profileMD5(shard.profile.allelesMap())
+	}
+	return SaveSeqDict(filepath.Join(dir, manifestFile), &SeqDict{Contigs: mg.contigs, ShardMD5: sums, Meta: mg.meta})
+}
+
+// contigGenome returns the multigenome of a single contig, with positions relative to its start.
+func (mg *Multigenome) contigGenome(c Contig) *Multigenome {
+	meta := make(map[string]string, len(mg.meta))
+	for k, v := range mg.meta {
+		meta[k] = v
+	}
+	return &Multigenome{
+		seq:     mg.seq[c.Offset : c.Offset+c.Len],
+		profile: mg.profile.slice(c.Offset, c.Offset+c.Len),
+		contigs: []Contig{{Name: c.Name, Offset: 0, Len: c.Len, MD5: c.MD5, Circular: c.Circular}},
+		meta:    meta,
+	}
+}
+
+// Sharded is a multigenome saved by SaveSharded whose contigs are loaded on first access.
+// It can be used from many goroutines at the same time.
+type Sharded struct {
+	dir    string
+	dict   *SeqDict
+	shards []shard
+}
+
+// shard is a contig of a sharded multigenome. Its lock is held while it is loaded, so that contigs
+// are loaded once, and different contigs at the same time.
+type shard struct {
+	mu sync.Mutex
+	mg *Multigenome // nil if not loaded
+}
+
+// OpenSharded opens a multigenome saved by SaveSharded. Only the manifest is read.
+func OpenSharded(dir string) (*Sharded, error) {
+	d, err := LoadSeqDict(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return nil, err
+	}
+	return &Sharded{dir: dir, dict: d, shards: make([]shard, len(d.Contigs))}, nil
+}
+
+// Contigs returns the contigs of the multigenome in genome order.
+func (s *Sharded) Contigs() []Contig {
+	return s.dict.Contigs
+}
+
+// Meta returns a metadata value of the multigenome.
+func (s *Sharded) Meta(key string) string {
+	return s.dict.Meta[key]
+}
+
+// Contig returns the multigenome of a contig, loading it if needed. Its positions are relative to
+// the start of the contig.
+func (s *Sharded) Contig(name string) (*Multigenome, error) {
+	k, err := s.index(name)
+	if err != nil {
+		return nil, err
+	}
+	sh := &s.shards[k]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.mg != nil {
+		return sh.mg, nil
+	}
+	file_name := filepath.Join(s.dir, shardFile(k))
+	mg, err := LoadBinary(file_name)
+	if err != nil {
+		return nil, err
+	}
+	if len(mg.contigs) != 1 || !sameContig(mg.contigs[0], s.dict.Contigs[k]) {
+		return nil, fmt.Errorf("%s: shard does not match contig %q of the manifest", file_name, name)
+	}
+	if sum, ok := s.dict.ShardMD5[k]; ok && sum != profileMD5(mg.profile.allelesMap()) {
+		return nil, fmt.Errorf("%s: shard does not match the SNP profile of contig %q in the manifest", file_name, name)
+	}
+	sh.mg = mg
+	return mg, nil
+}
+
+// Loaded returns whether a contig is loaded.
+func (s *Sharded) Loaded(name string) bool {
+	k, err := s.index(name)
+	if err != nil {
+		return false
+	}
+	s.shards[k].mu.Lock()
+	defer s.shards[k].mu.Unlock()
+	return s.shards[k].mg != nil
+}
+
+// Release releases a loaded contig; it is loaded again on its next access. Multigenomes returned
+// by Contig remain valid, their memory is freed when they are no longer used.
+func (s *Sharded) Release(name string) error {
+	k, err := s.index(name)
+	if err != nil {
+		return err
+	}
+	s.shards[k].mu.Lock()
+	defer s.shards[k].mu.Unlock()
+	s.shards[k].mg = nil
+	return nil
+}
+
+// Close releases all loaded contigs.
+func (s *Sharded) Close() error {
+	for k := range s.shards {
+		s.shards[k].mu.Lock()
+		s.shards[k].mg = nil
+		s.shards[k].mu.Unlock()
+	}
+	return nil
+}
+
+// sameContig returns whether a shard's contig is the contig of the manifest.
+func sameContig(c, want Contig) bool {
+	return c.Name == want.Name && c.Len == want.Len && c.Circular == want.Circular && (c.MD5 == "" || want.MD5 == "" || c.MD5 == want.MD5)
+}
+
+func (s *Sharded) index(name string) (int, error) {
+	for k, c := range s.dict.Contigs {
+		if c.Name == name {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("%s: unknown contig %q", s.dir, name)
+}
@@ -0,0 +1,109 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: test of sharded storage module.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSharded(t *testing.T) {
+	defer __(o_())
+
+	mg, err := Build("test_data/toy.fasta", "test_data/toy.vcf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "toy")
+	if err = mg.SaveSharded(dir); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenSharded(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Contigs()) != 2 || s.Meta("vcf") != "test_data/toy.vcf" || s.Loaded("chrA") {
+		t.Errorf("Fail opening sharded multigenome: %v", s.Contigs())
+	}
+
+	// Shard positions are relative to the start of the contig.
+	chrB, err := s.Contig("chrB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(chrB.Seq()) != "TTG*CCAT*ACA" || chrB.Profile().Len() != 2 || chrB.Profile().RefAllele(3) != 0 {
+		t.Errorf("Fail loading contig chrB: %s %v", chrB.Seq(), chrB.Profile().Positions())
+	}
+	if alleles, ok := chrB.Variants(8); !ok || len(alleles) != 2 || string(alleles[1]) != "GA" {
+		t.Errorf("Fail loading alleles of chrB: %q", alleles)
+	}
+	if !s.Loaded("chrB") || s.Loaded("chrA") {
+		t.Errorf("Fail loading contigs lazily")
+	}
+	chrA, err := s.Contig("chrA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := chrA.BackwardDistance([]byte("ACGTACGTATCGTTTA"), 4, 19)
+	if r.Distance() != 0 {
+		t.Errorf("Fail aligning to contig chrA: %d", r.Distance())
+	}
+	s.Release("chrA")
+	if s.Loaded("chrA") || !s.Loaded("chrB") {
+		t.Errorf("Fail releasing contig chrA")
+	}
+	if _, err = s.Contig("chrC"); err == nil {
+		t.Errorf("Fail reporting unknown contig")
+	}
+
+	// Contigs loaded at the same time are loaded once each.
+	var wg sync.WaitGroup
+	loaded := make([]*Multigenome, 8)
+	for i := range loaded {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			loaded[i], _ = s.Contig(s.Contigs()[i%2].Name)
+		}(i)
+	}
+	wg.Wait()
+	for i := range loaded {
+		if loaded[i] == nil || loaded[i] != loaded[i%2] || loaded[1] != chrB {
+			t.Errorf("Fail loading contigs at the same time")
+		}
+	}
+	if chrA2, _ := s.Contig("chrA"); chrA2 == chrA {
+		t.Errorf("Fail loading released contig chrA")
+	}
+
+	// A shard of the same contig saved with another SNP profile is detected.
+	vcf_file := filepath.Join(t.TempDir(), "other.vcf")
+	ioutil.WriteFile(vcf_file, []byte("chrB\t4\trs4\tA\tC\nchrB\t9\trs5\tG\tGT\n"), 0644)
+	other, err := Build("test_data/toy.fasta", vcf_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other_dir := filepath.Join(t.TempDir(), "other")
+	if err = other.SaveSharded(other_dir); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(other_dir, shardFile(1)))
+	ioutil.WriteFile(filepath.Join(dir, shardFile(1)), data, 0644)
+	s.Close()
+	if _, err = s.Contig("chrB"); err == nil {
+		t.Errorf("Fail detecting shard of another profile")
+	}
+
+	// A shard of another contig is detected.
+	os.Rename(filepath.Join(dir, shardFile(0)), filepath.Join(dir, shardFile(1)))
+	s.Close()
+	if _, err = s.Contig("chrB"); err == nil {
+		t.Errorf("Fail detecting wrong shard")
+	}
+}
@@ -0,0 +1,158 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: co-optimal alignment module.
+// The alleles chosen at variant sites by all the alignments of minimal distance, so that reads
+// which do not tell alleles apart can be recognized.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"sort"
+)
+
+// BackwardTies returns, for each variant site aligned by some alignment of minimal distance of s
+// and t, the indices of the alleles chosen there by such alignments in increasing order, from the
+// result of Backward (or BackwardQual, whose qualities the result keeps). Index -1 stands for a
+// site deleted from the read outside its alleles, in gapped alignments. A site with several
+// indices is ambiguous for the read.
+func (a *Aligner) BackwardTies(s, t []byte, r Result, pos int) map[int][]int {
+	return a.ties(dpView{s: s, t: t, pos: pos}, r)
+}
+
+// ForwardTies returns the alleles chosen by the alignments of minimal distance of s and t, from the
+// result of Forward, see BackwardTies.
+func (a *Aligner) ForwardTies(s, t []byte, r Result, pos int) map[int][]int {
+	return a.ties(dpView{s: s, t: t, pos: pos, fwd: true}, r)
+}
+
+// ties follows all the alignments of minimal distance from the anchored end: the part aligned
+// without dynamic programming, where a site is ambiguous if several alleles have the smallest cost,
+// then every DP predecessor of a cell which gives its distance, from every cell where a local
+// alignment of minimal distance ends. It returns nil if the result has no DP matrices.
+func (a *Aligner) ties(v dpView, r Result) map[int][]int {
+	if !r.OK || r.Distance() >= INF || r.ws == nil {
+		return nil
+	}
+	p := a.profile
+	sc := a.scorer()
+	v.q = r.q
+	a.window(&Workspace{}, &v)
+	ties := make(map[int][]int)
+	add := func(j, k int) {
+		pos := v.gpos(j)
+		for _, x := range ties[pos] {
+			if x == k {
+				return
+			}
+		}
+		ties[pos] = append(ties[pos], k)
+	}
+
+	m, n := len(v.s), len(v.t)
+	for n > r.N {
+		site := int(v.win[n])
+		if site < 0 {
+			m, n = m-1, n-1
+			continue
+		}
+		l := a.sameLen(&v, site, n)
+		min_d := 1000 * INF
+		for k := 0; k < p.numAlleles(site); k++ {
+			if d := v.allele(sc, m, p.allele(site, k)) + a.pen(site, k); d < min_d {
+				min_d = d
+			}
+		}
+		for k := 0; k < p.numAlleles(site); k++ {
+			if v.allele(sc, m, p.allele(site, k))+a.pen(site, k) == min_d {
+				add(n, k)
+			}
+		}
+		m, n = m-l, n-1
+	}
+
+	M, N := r.M, r.N
+	D := r.ws.D
+	if len(r.ws.runs) > 0 {
+		D = runCells(&v, r)
+	}
+	ins, del, open := a.cfg.Ins, a.cfg.Del, a.cfg.GapOpen
+	gapped := r.ws.gapped
+	seen := make([]bool, (M+1)*(N+1))
+	var stack []int
+	push := func(i, j int) {
+		if c := i*(N+1) + j; !seen[c] {
+			seen[c] = true
+			stack = append(stack, c)
+		}
+	}
+	if a.cfg.Mode == ModeLocal {
+		for i := 1; i <= M; i++ {
+			for j := 0; j <= N; j++ {
+				if D[i*(N+1)+j]+a.clipCost(M-i) == r.DPDist {
+					push(i, j)
+				}
+			}
+		}
+	} else {
+		push(M, N)
+	}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		i, j := c/(N+1), c%(N+1)
+		if i == 0 || j <= r.kern {
+			continue
+		}
+		site := int(v.win[j])
+		if site < 0 {
+			cost := D[c-N-2]
+			if v.base(i) != v.ref(j) {
+				cost += v.sub(sc, i, j)
+			}
+			if cost == D[c] {
+				push(i-1, j-1)
+			}
+		} else {
+			for k := 0; k < p.numAlleles(site); k++ {
+				allele := p.allele(site, k)
+				l, cost := len(allele), a.pen(site, k)
+				if allele[0] == '.' {
+					l = 0
+				} else if i >= l {
+					cost += v.allele(sc, i, allele)
+				} else {
+					continue
+				}
+				if D[(i-l)*(N+1)+j-1]+cost == D[c] {
+					add(j, k)
+					push(i-l, j-1)
+				}
+			}
+		}
+		if !gapped {
+			continue
+		}
+		for g := 1; ins > 0 && g <= i; g++ {
+			if D[(i-g)*(N+1)+j]+open+g*ins == D[c] {
+				push(i-g, j)
+			}
+		}
+		// Gaps do not cross the leading columns of the bit-parallel kernel, which have no sites and
+		// whose cells are not computed, see gappedDistance.
+		for g := 1; del > 0 && j-g >= r.kern; g++ {
+			if D[c-g]+open+g*del == D[c] {
+				for x := j - g + 1; x <= j; x++ {
+					if v.win[x] >= 0 {
+						add(x, -1)
+					}
+				}
+				push(i, j-g)
+			}
+		}
+	}
+	for _, k := range ties {
+		sort.Ints(k)
+	}
+	return ties
+}
@@ -0,0 +1,63 @@
+//----------------------------------------------------------------------------------------
+// Test for co-optimal alignments
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAlignerTies(t *testing.T) {
+	defer __(o_())
+
+	var test_cases = []struct {
+		profile      type_snpprofile
+		genome, read string
+		ins, del     int
+		ties         string
+	}{
+		{type_snpprofile{3: {{'A'}, {'C'}}}, "ACG*ACGT", "ACGAACGT", 0, 0, "map[3:[0]]"},
+		{type_snpprofile{3: {{'A'}, {'C'}}}, "ACG*ACGT", "ACGTACGT", 0, 0, "map[3:[0 1]]"},
+		{type_snpprofile{4: {{'C'}, {'G'}, {'.'}}}, "ACGT*ACGT", "ACGTTACGT", 0, 0, "map[4:[0 1]]"},
+		{type_snpprofile{4: {{'C'}, {'G'}, {'.'}}}, "ACGT*ACGT", "ACGTGACGT", 0, 0, "map[4:[1]]"},
+		{type_snpprofile{4: {{'C'}, {'G'}, {'.'}}}, "ACGT*ACGT", "ACGTTACGT", 1, 1, "map[4:[0 1 2]]"},
+		{type_snpprofile{4: {{'C'}}}, "ACGT*ACGT", "ACGTACGT", 1, 1, "map[4:[-1]]"},
+		{type_snpprofile{4: {{'A'}, {'.'}}}, "CCGT*AAGT", "CCGTAGT", 1, 1, "map[4:[1]]"},
+	}
+	for i, tc := range test_cases {
+		a := NewProfileAligner(NewProfile(tc.profile), Config{DistThres: INF, Ins: tc.ins, Del: tc.del, AlleleMismatches: true})
+		read, genome := []byte(tc.read), []byte(tc.genome)
+		r := a.Backward(read, genome, 0)
+		if ties := a.BackwardTies(read, genome, r, 0); fmt.Sprint(ties) != tc.ties {
+			t.Errorf("Fail backward ties (case %d): got %v, want %s", i, ties, tc.ties)
+		}
+		r = a.Forward(read, genome, 0)
+		if ties := a.ForwardTies(read, genome, r, 0); fmt.Sprint(ties) != tc.ties {
+			t.Errorf("Fail forward ties (case %d): got %v, want %s", i, ties, tc.ties)
+		}
+	}
+
+	// Ties of alignments with qualities are found with the costs of the qualities.
+	a := NewProfileAligner(NewProfile(type_snpprofile{3: {{'A'}, {'C'}}}), Config{DistThres: INF, Ins: 30, Del: 30})
+	read, genome, qual := []byte("ACGGACGT"), []byte("ACG*ACGT"), []byte("III&IIII")
+	if r, err := a.BackwardQual(read, qual, genome, 0); err != nil || r.Distance() != 8 || fmt.Sprint(a.BackwardTies(read, genome, r, 0)) != "map[3:[0 1]]" {
+		t.Errorf("Fail backward ties with qualities: got %d %v, want 8 map[3:[0 1]]", r.Distance(), a.BackwardTies(read, genome, r, 0))
+	}
+	if r, err := a.ForwardQual(read, qual, genome, 0); err != nil || r.Distance() != 8 || fmt.Sprint(a.ForwardTies(read, genome, r, 0)) != "map[3:[0 1]]" {
+		t.Errorf("Fail forward ties with qualities: got %d %v, want 8 map[3:[0 1]]", r.Distance(), a.ForwardTies(read, genome, r, 0))
+	}
+
+	// Local alignments are followed from the cells where they end, before the clipped bases.
+	a = NewProfileAligner(NewProfile(type_snpprofile{3: {{'A'}, {'C'}}}), Config{DistThres: INF, Mode: ModeLocal, Clip: 2})
+	read = []byte("ACGAACGTGGGG")
+	if r := a.Backward(read, genome, 0); r.AnchorClip != 4 || fmt.Sprint(a.BackwardTies(read, genome, r, 0)) != "map[3:[0]]" {
+		t.Errorf("Fail backward ties of a local alignment: got %d %v, want 4 map[3:[0]]", r.AnchorClip, a.BackwardTies(read, genome, r, 0))
+	}
+	read = []byte("GGGGACGAACGT")
+	if r := a.Forward(read, genome, 0); r.AnchorClip != 4 || fmt.Sprint(a.ForwardTies(read, genome, r, 0)) != "map[3:[0]]" {
+		t.Errorf("Fail forward ties of a local alignment: got %d %v, want 4 map[3:[0]]", r.AnchorClip, a.ForwardTies(read, genome, r, 0))
+	}
+}
@@ -0,0 +1,159 @@
+//-------------------------------------------------------------------------------------------------
+// Multigenome package: workspace module.
+// Reusable DP buffers, so that aligning many reads does not allocate once the buffers have grown
+// to the largest read.
+// Copyright 2014 Nam Sy Vo.
+//-------------------------------------------------------------------------------------------------
+
+package multigenome
+
+// Workspace holds the buffers of the alignments of an aligner. Alignments with a workspace do not
+// allocate memory once its buffers are large enough. The results and read bases returned by a
+// workspace are only valid until its next alignment or traceback. A workspace must not be used from
+// several goroutines at the same time; use one workspace per goroutine.
+type Workspace struct {
+	a *Aligner
+
+	// DP matrices, stored by rows: D has M+1 rows of N+1 distances, T, O and X have M rows of N
+	// cells. T holds the index+1 of the allele chosen at each variant site cell, 0 if none.
+	D    []int
+	E    []int
+	T    []uint16
+	O, X []byte // edit operations and gap extension flags of gapped alignments
+
+	gapped bool // whether the last alignment was gapped
+
+	win            []int32
+	lo, hi         []int // see dpBand
+	low, high      []int
+	w_min          []int
+	pv, mv, peq    []uint64 // see myers and myersRuns
+	peq_slot       [256]int // 1+index of the peq words of each base, 0 if none
+	runs           []myersRun
+	words          int    // words of the runs in pv, mv and each base of peq
+	blk            []int  // cells of a run recomputed by a traceback, see runBlock
+	seg            []byte // unpacked segment of a packed multigenome
+	calls, snp_out map[int][]byte
+}
+
+// NewWorkspace creates a workspace for the alignments of an aligner.
+func (a *Aligner) NewWorkspace() *Workspace {
+	return &Workspace{a: a, calls: make(map[int][]byte), snp_out: make(map[int][]byte)}
+}
+
+// Backward calculates the distance between s and t in backward direction, see Aligner.Backward.
+// The result has no Trace; alignments are traced back with BackwardTraceBack of the workspace.
+func (w *Workspace) Backward(s, t []byte, pos int) Result {
+	return w.a.align(w, dpView{s: s, t: t, pos: pos})
+}
+
+// Forward calculates the distance between s and t in forward direction, see Aligner.Forward.
+func (w *Workspace) Forward(s, t []byte, pos int) Result {
+	return w.a.align(w, dpView{s: s, t: t, pos: pos, fwd: true})
+}
+
+// BackwardQual calculates the distance between s and t with base qualities in backward direction,
+// see Aligner.BackwardQual.
+func (w *Workspace) BackwardQual(s, q, t []byte, pos int) (Result, error) {
+	if err := checkQual(s, q); err != nil {
+		return Result{}, err
+	}
+	r := w.a.align(w, dpView{s: s, q: q, t: t, pos: pos})
+	r.q = q
+	return r, nil
+}
+
+// ForwardQual calculates the distance between s and t with base qualities in forward direction.
+func (w *Workspace) ForwardQual(s, q, t []byte, pos int) (Result, error) {
+	if err := checkQual(s, q); err != nil {
+		return Result{}, err
+	}
+	r := w.a.align(w, dpView{s: s, q: q, t: t, pos: pos, fwd: true})
+	r.q = q
+	return r, nil
+}
+
+// BackwardTraceBack returns the read bases aligned to the variant sites of t by the last alignment
+// of the workspace, in backward direction. The map is reused by the next traceback.
+func (w *Workspace) BackwardTraceBack(s, t []byte, r Result, pos int) map[int][]byte {
+	return w.traceBack(dpView{s: s, t: t, pos: pos}, r)
+}
+
+// ForwardTraceBack returns the read bases aligned to the variant sites of t by the last alignment
+// of the workspace, in forward direction. The map is reused by the next traceback.
+func (w *Workspace) ForwardTraceBack(s, t []byte, r Result, pos int) map[int][]byte {
+	return w.traceBack(dpView{s: s, t: t, pos: pos, fwd: true}, r)
+}
+
+func (w *Workspace) traceBack(v dpView, r Result) map[int][]byte {
+	w.a.window(w, &v)
+	clearCalls(w.snp_out)
+	w.a.trace(v, r, w.snp_out)
+	return w.snp_out
+}
+
+// resize sizes the DP matrices for m read bases and n genome bases.
+func (w *Workspace) resize(m, n int) {
+	w.D = growInts(w.D, (m+1)*(n+1))
+	w.E = growInts(w.E, n+1)
+	w.T = growUint16s(w.T, m*n)
+	w.O = growBytes(w.O, m*n)
+	w.X = growBytes(w.X, m*n)
+}
+
+// traceMatrix returns the alleles chosen at the variant sites of the last DP, of m rows and n
+// columns, as in Result.Trace.
+func (w *Workspace) traceMatrix(m, n int) [][][]byte {
+	T := make([][][]byte, m)
+	for i := 0; i < m; i++ {
+		T[i] = make([][]byte, n)
+		for j := 0; j < n; j++ {
+			if k := w.T[i*n+j]; k != 0 && k != tclip {
+				T[i][j] = w.a.profile.allele(int(w.win[j+1]), int(k)-1)
+			}
+		}
+	}
+	return T
+}
+
+// clearCalls empties a map of variant calls, keeping its memory.
+func clearCalls(calls map[int][]byte) {
+	for k := range calls {
+		delete(calls, k)
+	}
+}
+
+func growInts(b []int, n int) []int {
+	if cap(b) < n {
+		return make([]int, n)
+	}
+	return b[:n]
+}
+
+func growInt32s(b []int32, n int) []int32 {
+	if cap(b) < n {
+		return make([]int32, n)
+	}
+	return b[:n]
+}
+
+func growUint16s(b []uint16, n int) []uint16 {
+	if cap(b) < n {
+		return make([]uint16, n)
+	}
+	return b[:n]
+}
+
+func growUint64s(b []uint64, n int) []uint64 {
+	if cap(b) < n {
+		return make([]uint64, n)
+	}
+	return b[:n]
+}
+
+func growBytes(b []byte, n int) []byte {
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+	return b[:n]
+}
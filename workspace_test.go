@@ -0,0 +1,78 @@
+//----------------------------------------------------------------------------------------
+// Test for workspaces
+// Copyright 2014 Nam Sy Vo
+//----------------------------------------------------------------------------------------
+
+package multigenome
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// Alignments with a workspace are the same as with the aligner, and do not allocate.
+func TestWorkspace(t *testing.T) {
+	defer __(o_())
+
+	genome, profile, reads := bandData(rand.New(rand.NewSource(7)))
+	p, err := NewProfile(profile).WithFreqs(map[int][]float64{22: {0.9, 0.1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cfg := range []Config{
+		{DistThres: INF},
+		{DistThres: 3},
+		{DistThres: INF, Ins: 1, Del: 1},
+		{DistThres: 4, Ins: 2, Del: 1, GapOpen: 2, PriorWeight: 1},
+		{DistThres: 3, Mode: ModeSemiGlobal, Clip: 2},
+		{DistThres: INF, Ins: 1, Del: 1, Mode: ModeLocal, Clip: 3},
+	} {
+		a := NewProfileAligner(p, cfg)
+		w := a.NewWorkspace()
+		for k, read := range reads {
+			r1, r2 := a.Backward(read, genome, 0), w.Backward(read, genome, 0)
+			if r1.Distance() != r2.Distance() || r1.Prior != r2.Prior {
+				t.Errorf("Fail workspace backward alignment (config %v, read %d): got %d, want %d", cfg, k, r2.Distance(), r1.Distance())
+				continue
+			}
+			if r1.Distance() <= cfg.DistThres && r1.Distance() < INF {
+				s1, s2 := a.BackwardTraceBack(read, genome, r1, 0), w.BackwardTraceBack(read, genome, r2, 0)
+				if fmt.Sprint(s1) != fmt.Sprint(s2) {
+					t.Errorf("Fail workspace backward traceback (config %v, read %d): got %v, want %v", cfg, k, s2, s1)
+				}
+			}
+			r1, r2 = a.Forward(read, genome, 0), w.Forward(read, genome, 0)
+			if r1.Distance() != r2.Distance() || r1.Prior != r2.Prior {
+				t.Errorf("Fail workspace forward alignment (config %v, read %d): got %d, want %d", cfg, k, r2.Distance(), r1.Distance())
+				continue
+			}
+			if r1.Distance() <= cfg.DistThres && r1.Distance() < INF {
+				s1, s2 := a.ForwardTraceBack(read, genome, r1, 0), w.ForwardTraceBack(read, genome, r2, 0)
+				if fmt.Sprint(s1) != fmt.Sprint(s2) {
+					t.Errorf("Fail workspace forward traceback (config %v, read %d): got %v, want %v", cfg, k, s2, s1)
+				}
+			}
+		}
+
+		allocs := testing.AllocsPerRun(10, func() {
+			for _, read := range reads {
+				r := w.Backward(read, genome, 0)
+				if r.Distance() <= cfg.DistThres && r.Distance() < INF {
+					w.BackwardTraceBack(read, genome, r, 0)
+				}
+				r = w.Forward(read, genome, 0)
+				if r.Distance() <= cfg.DistThres && r.Distance() < INF {
+					w.ForwardTraceBack(read, genome, r, 0)
+				}
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("Fail reusing workspace (config %v): %v allocations", cfg, allocs)
+		}
+	}
+}
+
+func BenchmarkBackwardWorkspace(b *testing.B) {
+	benchGapped(b, nil, true, false)
+}